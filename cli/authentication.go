@@ -8,6 +8,10 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"log"
 	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -15,46 +19,89 @@ const (
 	authorityUrl = "https://login.microsoftonline.com" // Authority URL for user authentication
 )
 
+// Modes which can be used to authenticate with an Azure Digital Twin instance
+const (
+	AuthAzureCli          = "cli"               // The credentials of the signed in Azure CLI user
+	AuthClientSecret      = "secret"            // An app registration using a client secret
+	AuthClientCertificate = "certificate"       // An app registration using a PEM or PFX client certificate
+	AuthManagedIdentity   = "managed-identity"  // The system-assigned managed identity, or a user-assigned one given by its client id
+	AuthWorkloadIdentity  = "workload-identity" // A federated token, such as a Kubernetes service account token, exchanged for an app registration
+	AuthDeviceCode        = "device-code"       // A user signing in on another device using a code
+	AuthEnvironment       = "environment"       // An app registration or user described by AZURE_* environment variables
+	AuthDefault           = "default"           // Environment variables, workload identity, managed identity and the Azure CLI, in that order
+)
+
+// AuthenticationModes lists each of the modes which can be used to authenticate
+var AuthenticationModes = []string{AuthAzureCli, AuthClientSecret, AuthClientCertificate, AuthManagedIdentity, AuthWorkloadIdentity, AuthDeviceCode, AuthEnvironment, AuthDefault}
+
 // AuthenticationMethod defined how the application will authenticate with an Azure Digital Twin instance
 type AuthenticationMethod struct {
-	UseAzureCli  bool   // Indicates if the Azure CLI credential should be used
-	TenantId     string // When using client credentials, specifies the Azure tenant to authenticate against
-	ClientId     string // The id of the client used for client credential authentication
-	ClientSecret string // The secret of the client used for client credential authentication
+	Mode                string // The mode used to authenticate, when not set UseAzureCli decides between the Azure CLI and a client secret
+	UseAzureCli         bool   // Indicates if the Azure CLI credential should be used
+	TenantId            string // When using client credentials, specifies the Azure tenant to authenticate against
+	ClientId            string // The id of the client used for client credential authentication, or of a user-assigned managed identity
+	ClientSecret        string // The secret of the client used for client credential authentication
+	CertificatePath     string // The PEM or PFX file holding the certificate, and its private key, used for client certificate authentication
+	CertificatePassword string // The password of the certificate, if it has one
+	FederatedTokenFile  string // The file holding the federated token used for workload identity authentication
+}
+
+// Gets the mode used to authenticate
+func (method AuthenticationMethod) mode() string {
+	if len(method.Mode) > 0 {
+		return strings.ToLower(method.Mode)
+	} else if method.UseAzureCli {
+		return AuthAzureCli
+	}
+	return AuthClientSecret
+}
+
+// Connection defines the Azure Digital Twin instance to connect to and how to connect to it
+type Connection struct {
+	Endpoint       string                // Endpoint of the Azure Digital Twin instance
+	Authentication *AuthenticationMethod // How to authenticate with the instance
+	Retry          RetryPolicy           // How requests which are throttled or fail with a transient error are retried
+	RequestTimeout time.Duration         // The maximum time a single request is allowed to take, with zero meaning DefaultRequestTimeout
 }
 
 // Describes all the configuration required for interacting with an Azure Digital Twin instance
 type twinConfiguration struct {
-	endpoint     url.URL  // The URL of the Azure Digital Twin instance
-	useAzureCli  bool     // Indicates if the Azure CLI credential should be used
-	tenantId     string   // When using client credentials, specifies the Azure tenant to authenticate against
-	clientId     string   // The id of the client used for client credential authentication
-	clientSecret string   // The secret of the client used for client credential authentication
-	scopes       []string // The scopes to create an authentication token for
-	authorityUrl url.URL  // Authority URL required for authenticating the user
+	endpoint       url.URL                // The URL of the Azure Digital Twin instance
+	authentication AuthenticationMethod   // How to authenticate with the instance
+	credential     azcore.TokenCredential // The credential created for the authentication method, once a token has been requested
+	credentialLock sync.Mutex             // Guards the creation of the credential, as tokens can be requested concurrently
+	scopes         []string               // The scopes to create an authentication token for
+	authorityUrl   url.URL                // Authority URL required for authenticating the user
+	retry          RetryPolicy            // How requests which are throttled or fail with a transient error are retried
+	requestTimeout time.Duration          // The maximum time a single request is allowed to take
 }
 
-// Creates a new twinConfiguration instance using the endpoint and AuthenticationMethod information provided
-func newTwinConfiguration(endpoint string, authenticationMethod *AuthenticationMethod) (*twinConfiguration, error) {
+// Creates a new twinConfiguration instance using the endpoint, AuthenticationMethod, retry and timeout information
+// provided
+func newTwinConfiguration(connection Connection) (*twinConfiguration, error) {
+	authenticationMethod := connection.Authentication
 	authority, _ := url.Parse(authorityUrl)
 	var scopes []string
 
-	if authenticationMethod.UseAzureCli {
+	if authenticationMethod.mode() == AuthAzureCli {
 		scopes = []string{resourceId}
 	} else {
 		scopes = []string{fmt.Sprintf("%s/.default", resourceId)}
 	}
 
 	config := twinConfiguration{
-		scopes:       scopes,
-		authorityUrl: *authority,
-		useAzureCli:  authenticationMethod.UseAzureCli,
-		tenantId:     authenticationMethod.TenantId,
-		clientId:     authenticationMethod.ClientId,
-		clientSecret: authenticationMethod.ClientSecret,
+		scopes:         scopes,
+		authorityUrl:   *authority,
+		authentication: *authenticationMethod,
+		retry:          connection.Retry,
+		requestTimeout: connection.RequestTimeout,
+	}
+
+	if config.requestTimeout <= 0 {
+		config.requestTimeout = DefaultRequestTimeout
 	}
 
-	err := config.setAdtEndpoint(endpoint)
+	err := config.setAdtEndpoint(connection.Endpoint)
 	if err != nil {
 		return nil, err
 	}
@@ -74,21 +121,14 @@ func (configuration *twinConfiguration) setAdtEndpoint(endpoint string) error {
 	return nil
 }
 
-// Gets a bearer token for the twinConfiguration instance
-func (configuration *twinConfiguration) getBearerToken() (*azcore.AccessToken, error) {
-	var credentials azcore.TokenCredential
-	var err error
-	if configuration.useAzureCli {
-		credentials, err = azidentity.NewAzureCLICredential(nil)
-	} else {
-		credentials, err = azidentity.NewClientSecretCredential(configuration.tenantId, configuration.clientId, configuration.clientSecret, nil)
-	}
-
+// Gets a bearer token for the twinConfiguration instance. The credential is created on the first request and then
+// reused, so that tokens it has acquired (and any device code sign in) are not repeated for every request
+func (configuration *twinConfiguration) getBearerToken(ctx context.Context) (*azcore.AccessToken, error) {
+	credentials, err := configuration.getCredential()
 	if err != nil {
 		return nil, fmt.Errorf("unable to create credentials: %s", err)
 	}
 
-	ctx := context.Background()
 	tokenRequestOptions := policy.TokenRequestOptions{Scopes: configuration.scopes}
 
 	log.Print("Getting bearer token")
@@ -100,3 +140,70 @@ func (configuration *twinConfiguration) getBearerToken() (*azcore.AccessToken, e
 
 	return &token, nil
 }
+
+// Gets the credential for the authentication method, creating it if this is the first time it is needed
+func (configuration *twinConfiguration) getCredential() (azcore.TokenCredential, error) {
+	configuration.credentialLock.Lock()
+	defer configuration.credentialLock.Unlock()
+
+	if configuration.credential != nil {
+		return configuration.credential, nil
+	}
+
+	credential, err := newCredential(configuration.authentication)
+	if err != nil {
+		return nil, err
+	}
+
+	configuration.credential = credential
+	return credential, nil
+}
+
+// Creates the credential used to acquire tokens for the authentication method
+func newCredential(method AuthenticationMethod) (azcore.TokenCredential, error) {
+	switch method.mode() {
+	case AuthAzureCli:
+		return azidentity.NewAzureCLICredential(nil)
+	case AuthClientSecret:
+		return azidentity.NewClientSecretCredential(method.TenantId, method.ClientId, method.ClientSecret, nil)
+	case AuthClientCertificate:
+		content, err := os.ReadFile(method.CertificatePath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read certificate %s: %s", method.CertificatePath, err)
+		}
+
+		certificates, key, err := azidentity.ParseCertificates(content, []byte(method.CertificatePassword))
+		if err != nil {
+			return nil, fmt.Errorf("unable to load certificate %s: %s", method.CertificatePath, err)
+		}
+
+		return azidentity.NewClientCertificateCredential(method.TenantId, method.ClientId, certificates, key, nil)
+	case AuthManagedIdentity:
+		options := azidentity.ManagedIdentityCredentialOptions{}
+		if len(method.ClientId) > 0 {
+			options.ID = azidentity.ClientID(method.ClientId)
+		}
+		return azidentity.NewManagedIdentityCredential(&options)
+	case AuthWorkloadIdentity:
+		return newWorkloadIdentityCredential(method)
+	case AuthDeviceCode:
+		return azidentity.NewDeviceCodeCredential(&azidentity.DeviceCodeCredentialOptions{TenantID: method.TenantId, ClientID: method.ClientId})
+	case AuthEnvironment:
+		return azidentity.NewEnvironmentCredential(nil)
+	case AuthDefault:
+		return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{TenantID: method.TenantId})
+	default:
+		return nil, fmt.Errorf("the authentication mode '%s' is not supported", method.Mode)
+	}
+}
+
+// Creates a credential which exchanges a federated token for an access token of an app registration. Values which are
+// not given by the authentication method are read from the AZURE_TENANT_ID, AZURE_CLIENT_ID and
+// AZURE_FEDERATED_TOKEN_FILE environment variables
+func newWorkloadIdentityCredential(method AuthenticationMethod) (azcore.TokenCredential, error) {
+	return azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+		TenantID:      method.TenantId,
+		ClientID:      method.ClientId,
+		TokenFilePath: method.FederatedTokenFile,
+	})
+}
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_newTwinConfiguration_requestTimeout(t *testing.T) {
+	tests := []struct {
+		timeout  time.Duration
+		expected time.Duration
+	}{
+		{0, DefaultRequestTimeout},
+		{30 * time.Second, 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected.String(), func(t *testing.T) {
+			connection := Connection{Endpoint: "https://example.api.weu.digitaltwins.azure.net", Authentication: &AuthenticationMethod{Mode: AuthDefault}, RequestTimeout: tt.timeout}
+			config, err := newTwinConfiguration(connection)
+			if err != nil {
+				t.Fatalf("Expected a configuration, but got error: %s", err)
+			}
+
+			if timeout := newClient(config).httpClient.Timeout; timeout != tt.expected {
+				t.Errorf("Expected a request timeout of %s, but got %s", tt.expected, timeout)
+			}
+		})
+	}
+}
+
+func TestAuthenticationMethod_mode(t *testing.T) {
+	tests := []struct {
+		method   AuthenticationMethod
+		expected string
+	}{
+		{AuthenticationMethod{}, AuthClientSecret},
+		{AuthenticationMethod{UseAzureCli: true}, AuthAzureCli},
+		{AuthenticationMethod{Mode: "Managed-Identity"}, AuthManagedIdentity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			if actual := tt.method.mode(); actual != tt.expected {
+				t.Errorf("Expected %s but got %s", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_newCredential_workloadIdentity(t *testing.T) {
+	for _, variable := range []string{"AZURE_TENANT_ID", "AZURE_CLIENT_ID", "AZURE_FEDERATED_TOKEN_FILE"} {
+		t.Setenv(variable, "")
+	}
+
+	if _, err := newCredential(AuthenticationMethod{Mode: AuthWorkloadIdentity}); err == nil {
+		t.Errorf("Expected an error when no federated token file is given")
+	}
+
+	method := AuthenticationMethod{Mode: AuthWorkloadIdentity, TenantId: "tenant", ClientId: "client", FederatedTokenFile: "/var/run/token"}
+	if _, err := newCredential(method); err != nil {
+		t.Errorf("Expected a credential from the flags, but got error: %s", err)
+	}
+
+	t.Setenv("AZURE_TENANT_ID", "tenant")
+	t.Setenv("AZURE_CLIENT_ID", "client")
+	t.Setenv("AZURE_FEDERATED_TOKEN_FILE", "/var/run/token")
+	if _, err := newCredential(AuthenticationMethod{Mode: AuthWorkloadIdentity}); err != nil {
+		t.Errorf("Expected a credential from the environment, but got error: %s", err)
+	}
+}
@@ -0,0 +1,182 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DefaultMaxRequestBytes is the default maximum size of the body of a request used to upload models
+const DefaultMaxRequestBytes = 1024 * 1024
+
+// batchLimits defines the limits which each batch of models uploaded in a single request must keep within
+type batchLimits struct {
+	maxModels int // The maximum number of models in a batch, with zero meaning the API limits are used
+	maxBytes  int // The maximum size of the request body for a batch in bytes, with zero meaning no limit
+}
+
+// Creates the limits used to upload models in requests of at most maxBytes, with zero meaning DefaultMaxRequestBytes
+func uploadBatchLimits(maxBytes int) batchLimits {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxRequestBytes
+	}
+	return batchLimits{maxBytes: maxBytes}
+}
+
+// Creates the limits for uploading the models, using the maximum request size given. Sets of fewer models than the API
+// limit may be uploaded in a single request, otherwise smaller batches are used
+func newBatchLimits(modelCount int, maxBytes int) batchLimits {
+	limits := batchLimits{maxModels: maxModelsPerBatch, maxBytes: maxBytes}
+	if modelCount < maxModelsApiLimit {
+		limits.maxModels = maxModelsApiLimit
+	}
+	return limits
+}
+
+// A set of models which must be uploaded in the same batch, along with its dependency level and request size
+type batchGroup struct {
+	models []*modelEntry // The models in the group
+	level  int           // The dependency level of the group, with groups which reference nothing in the set at level 0
+	size   int           // The number of bytes the models add to a request body
+}
+
+// Splits the models into the batches they should be uploaded in. Models are packed into batches by dependency level,
+// so that each batch only references models which are in an earlier batch, in the same batch, or are not in the
+// collection being uploaded (and so must already exist). Models which target each other through relationships are
+// always kept in the same batch. An error is returned if a model, or a set of models which must be kept together,
+// cannot fit into a single request within the limits
+func planBatches(models []*modelEntry, limits batchLimits) ([][]*modelEntry, error) {
+	if limits.maxModels <= 0 {
+		limits = newBatchLimits(len(models), limits.maxBytes)
+	}
+
+	groups, err := groupModelsByLevel(models)
+	if err != nil {
+		return nil, err
+	}
+
+	batches := make([][]*modelEntry, 0)
+	current := make([]*modelEntry, 0)
+	currentSize := 0
+
+	for _, group := range groups {
+		if err = limits.check(group); err != nil {
+			return nil, err
+		}
+
+		if len(current) > 0 && !limits.fits(len(current)+len(group.models), currentSize+group.size) {
+			batches = append(batches, current)
+			current = make([]*modelEntry, 0)
+			currentSize = 0
+		}
+
+		current = append(current, group.models...)
+		currentSize += group.size
+	}
+
+	if len(current) > 0 {
+		batches = append(batches, current)
+	}
+
+	return batches, nil
+}
+
+// Groups the models so that models which target each other through relationships are together, and orders the groups
+// by their dependency level. Only references between models in the collection are considered
+func groupModelsByLevel(models []*modelEntry) ([]batchGroup, error) {
+	included := make(map[*modelEntry]bool)
+	for _, entry := range models {
+		included[entry] = true
+	}
+
+	sortedGroups, err := sortModelGroups(models)
+	if err != nil {
+		return nil, err
+	}
+
+	groupOf := make(map[*modelEntry]int)
+	groups := make([]batchGroup, 0, len(sortedGroups))
+	maxLevel := 0
+
+	for _, sortedGroup := range sortedGroups {
+		group := batchGroup{}
+		for _, entry := range sortedGroup {
+			if !included[entry] {
+				continue
+			}
+
+			content, err := json.Marshal(entry.model)
+			if err != nil {
+				return nil, fmt.Errorf("unable to convert model %s to JSON: %s", entry.modelId, err)
+			}
+
+			group.models = append(group.models, entry)
+			group.size += len(content) + 1 // Allow for the separator between models in the array
+		}
+
+		if len(group.models) == 0 {
+			continue
+		}
+
+		// Groups are in dependency order, so the level of every group referenced is already known
+		for _, entry := range group.models {
+			for _, edges := range [][]*modelEntry{entry.dependencies, entry.targets} {
+				for _, reference := range edges {
+					if index, ok := groupOf[reference]; ok && groups[index].level >= group.level {
+						group.level = groups[index].level + 1
+					}
+				}
+			}
+		}
+
+		for _, entry := range group.models {
+			groupOf[entry] = len(groups)
+		}
+		groups = append(groups, group)
+
+		if group.level > maxLevel {
+			maxLevel = group.level
+		}
+	}
+
+	ordered := make([]batchGroup, 0, len(groups))
+	for level := 0; level <= maxLevel; level++ {
+		for _, group := range groups {
+			if group.level == level {
+				ordered = append(ordered, group)
+			}
+		}
+	}
+
+	return ordered, nil
+}
+
+// Checks if a batch with the given number of models and size would be within the limits. The size of a batch includes
+// the brackets of the JSON array
+func (limits batchLimits) fits(modelCount int, size int) bool {
+	if modelCount > limits.maxModels {
+		return false
+	}
+	return limits.maxBytes <= 0 || size+1 <= limits.maxBytes
+}
+
+// Checks that a group of models can be uploaded in a single request, returning an error describing why not if it
+// cannot be. A group with more models than the batch size is allowed as long as it is within the API limit, and is
+// uploaded in a batch of its own
+func (limits batchLimits) check(group batchGroup) error {
+	if len(group.models) <= maxModelsApiLimit && (limits.maxBytes <= 0 || group.size+1 <= limits.maxBytes) {
+		return nil
+	}
+
+	if len(group.models) == 1 {
+		return fmt.Errorf("model %s is %d bytes, which is larger than the maximum request size of %d bytes", group.models[0].location(), group.size-1, limits.maxBytes)
+	}
+
+	ids := make([]string, len(group.models))
+	for i, entry := range group.models {
+		ids[i] = entry.modelId
+	}
+
+	return fmt.Errorf("models which target each other through relationships must be uploaded in the same request, but %d model(s) (%d bytes) exceed the limit of %d model(s) and %d bytes per request: %s",
+		len(group.models), group.size+1, maxModelsApiLimit, limits.maxBytes, strings.Join(ids, ", "))
+}
@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Checks that every model referenced by a batch is in an earlier batch, the same batch, or outside the set uploaded
+func assertBatchOrder(t *testing.T, models []*modelEntry, batches [][]*modelEntry) {
+	batchOf := make(map[*modelEntry]int)
+	for i, batch := range batches {
+		for _, entry := range batch {
+			batchOf[entry] = i
+		}
+	}
+
+	count := 0
+	for i, batch := range batches {
+		count += len(batch)
+		for _, entry := range batch {
+			for _, edges := range [][]*modelEntry{entry.dependencies, entry.targets} {
+				for _, reference := range edges {
+					if index, ok := batchOf[reference]; ok && index > i {
+						t.Errorf("Model %s in batch %d references %s which is in the later batch %d", entry.modelId, i, reference.modelId, index)
+					}
+				}
+			}
+		}
+	}
+
+	if count != len(models) {
+		t.Errorf("Expected %d models in the batches, but got %d", len(models), count)
+	}
+}
+
+// Creates a chain of models where each model extends the previous one
+func newModelChain(t *testing.T, count int) []*modelEntry {
+	models := make([]*modelEntry, count)
+	for i := range models {
+		if i == 0 {
+			models[i] = newTestEntry(t, `{"@id": "dtmi:test:model0;1", "@type": "Interface"}`)
+		} else {
+			models[i] = newTestEntry(t, fmt.Sprintf(`{"@id": "dtmi:test:model%d;1", "@type": "Interface", "extends": "dtmi:test:model%d;1"}`, i, i-1))
+		}
+	}
+	setModelDependencies(models)
+	return models
+}
+
+func Test_planBatches_singleBatch(t *testing.T) {
+	d := ModelDirectory{}
+	_ = d.Set("../testdata/models")
+	models, _ := d.getModels()
+	setModelDependencies(models)
+
+	batches, err := planBatches(models, batchLimits{maxBytes: DefaultMaxRequestBytes})
+	if err != nil {
+		t.Fatalf("Expected batches to be planned, but received error: %s", err)
+	}
+
+	if len(batches) != 1 {
+		t.Fatalf("Expected a single batch, but got %d", len(batches))
+	}
+	assertBatchOrder(t, models, batches)
+}
+
+func Test_planBatches_modelCount(t *testing.T) {
+	models := newModelChain(t, 300)
+
+	batches, err := planBatches(reverseModels(models), batchLimits{})
+	if err != nil {
+		t.Fatalf("Expected batches to be planned, but received error: %s", err)
+	}
+
+	if len(batches) != 8 {
+		t.Errorf("Expected 8 batches, but got %d", len(batches))
+	}
+
+	for i, batch := range batches {
+		if len(batch) > maxModelsPerBatch {
+			t.Errorf("Expected batch %d to contain at most %d models, but it has %d", i, maxModelsPerBatch, len(batch))
+		}
+	}
+	assertBatchOrder(t, models, batches)
+}
+
+func Test_planBatches_requestSize(t *testing.T) {
+	models := newModelChain(t, 20)
+	maxBytes := 400
+
+	batches, err := planBatches(models, batchLimits{maxBytes: maxBytes})
+	if err != nil {
+		t.Fatalf("Expected batches to be planned, but received error: %s", err)
+	}
+
+	if len(batches) < 2 {
+		t.Fatalf("Expected the models to be split over multiple batches, but got %d", len(batches))
+	}
+
+	for i, batch := range batches {
+		content, _ := json.Marshal(batchToJsonArray(batch))
+		if len(content) > maxBytes {
+			t.Errorf("Expected batch %d to be at most %d bytes, but it is %d bytes", i, maxBytes, len(content))
+		}
+	}
+	assertBatchOrder(t, models, batches)
+}
+
+func Test_planBatches_relationshipCycle(t *testing.T) {
+	building := newTestEntry(t, `{"@id": "dtmi:test:building;1", "@type": "Interface", "contents": [{"@type": "Relationship", "name": "levels", "target": "dtmi:test:level;1"}]}`)
+	level := newTestEntry(t, `{"@id": "dtmi:test:level;1", "@type": "Interface", "contents": [{"@type": "Relationship", "name": "building", "target": "dtmi:test:building;1"}]}`)
+	other := newTestEntry(t, `{"@id": "dtmi:test:other;1", "@type": "Interface"}`)
+	models := []*modelEntry{building, other, level}
+	setModelDependencies(models)
+
+	// Only allow two models per batch, so the cycle must be kept together
+	batches, err := planBatches(models, batchLimits{maxModels: 2})
+	if err != nil {
+		t.Fatalf("Expected batches to be planned, but received error: %s", err)
+	}
+
+	for _, batch := range batches {
+		hasBuilding, hasLevel := false, false
+		for _, entry := range batch {
+			hasBuilding = hasBuilding || entry == building
+			hasLevel = hasLevel || entry == level
+		}
+		if hasBuilding != hasLevel {
+			t.Errorf("Expected the building and level models to be in the same batch")
+		}
+	}
+	assertBatchOrder(t, models, batches)
+}
+
+func Test_planBatches_modelTooLarge(t *testing.T) {
+	models := []*modelEntry{
+		newTestEntry(t, `{"@id": "dtmi:test:small;1", "@type": "Interface"}`),
+		newTestEntry(t, fmt.Sprintf(`{"@id": "dtmi:test:large;1", "@type": "Interface", "description": "%s"}`, strings.Repeat("x", 1000))),
+	}
+	setModelDependencies(models)
+
+	_, err := planBatches(models, batchLimits{maxBytes: 500})
+	if err == nil || !strings.Contains(err.Error(), "dtmi:test:large;1") {
+		t.Errorf("Expected an error for the model which is too large, but got: %v", err)
+	}
+}
@@ -2,13 +2,18 @@ package cli
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"io"
 	"log"
-	"math"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -17,24 +22,37 @@ const (
 	apiVersion        = "2020-10-31" // Digital Twin Rest API version to use
 )
 
+// DefaultRequestTimeout is the maximum time a single request to the API is allowed to take when no other timeout is
+// specified
+const DefaultRequestTimeout = 5 * time.Minute
+
 // pagedDigitalTwinsModelDataCollection defines a paged response from the Azure Digital Twin GET model API. It contains
 // a list of digital twin models, and a continuation token to retrieve more results
 type pagedDigitalTwinsModelDataCollection struct {
-	NextLink string       `json:"nextLink"` // The continuation token if provided
-	Value    []jsonObject `json:"value"`    // Collection of models
+	NextLink string                  `json:"nextLink"` // The continuation token if provided
+	Value    []digitalTwinsModelData `json:"value"`    // Collection of models
+}
+
+// digitalTwinsModelData defines a single model returned by the Azure Digital Twin GET model API
+type digitalTwinsModelData struct {
+	Id             string     `json:"id"`             // The id of the model
+	Decommissioned bool       `json:"decommissioned"` // Indicates if the model has been decommissioned
+	UploadTime     string     `json:"uploadTime"`     // When the model was uploaded to the instance
+	Model          jsonObject `json:"model"`          // The model definition
 }
 
 // client managed connecting to the Azure Digital Twin resource
 type client struct {
 	configuration *twinConfiguration
 	httpClient    *http.Client
+	dryRun        bool // When set, requests which would change the instance are printed instead of being sent
 }
 
 // Creates a new instance of the client type
 func newClient(configuration *twinConfiguration) *client {
 	return &client{
 		configuration: configuration,
-		httpClient:    &http.Client{},
+		httpClient:    &http.Client{Timeout: configuration.requestTimeout},
 	}
 }
 
@@ -63,10 +81,10 @@ func (client *client) getModelUrl(modelId *string, parameters *map[string]string
 }
 
 // Gets all the models from the Azure Digital Twin instance
-func (client *client) listModels() ([]*modelEntry, error) {
+func (client *client) listModels(ctx context.Context) ([]*modelEntry, error) {
 	results := make([]*modelEntry, 0)
 
-	token, err := client.configuration.getBearerToken()
+	token, err := client.configuration.getBearerToken(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -74,14 +92,12 @@ func (client *client) listModels() ([]*modelEntry, error) {
 	endpoint := client.getModelUrl(nil, &map[string]string{"includeModelDefinition": "true"})
 
 	for {
-		req, _ := http.NewRequest("GET", endpoint, nil)
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.Token))
-		req.Header.Set("Accept", "application/json")
-
 		log.Printf("Retrieving models from: %s", endpoint)
 
-		resp, err := client.httpClient.Do(req)
-		if err != nil {
+		resp, err := client.send(ctx, http.MethodGet, endpoint, nil, "", token)
+		if ctx.Err() != nil {
+			return nil, cancellationError(ctx, fmt.Sprintf("retrieving %d models", len(results)))
+		} else if err != nil {
 			return nil, fmt.Errorf("unable to retrieve data from %s\n%s", endpoint, err)
 		} else if resp.StatusCode != 200 {
 			return nil, handleResponseError(resp)
@@ -89,10 +105,16 @@ func (client *client) listModels() ([]*modelEntry, error) {
 
 		var pagedResult pagedDigitalTwinsModelDataCollection
 		respContent, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
 		_ = json.Unmarshal(respContent, &pagedResult)
 
 		for i := range pagedResult.Value {
-			entry, _ := newModelEntry(pagedResult.Value[i])
+			entry, err := newModelEntry(pagedResult.Value[i].Model)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read definition of model %s: %s", pagedResult.Value[i].Id, err)
+			}
+			entry.decommissioned = pagedResult.Value[i].Decommissioned
+			entry.uploadTime = pagedResult.Value[i].UploadTime
 			results = append(results, entry)
 		}
 
@@ -106,56 +128,139 @@ func (client *client) listModels() ([]*modelEntry, error) {
 	return results, nil
 }
 
-// Removes all models which have been added to the Azure Digital Twin instance
-func (client *client) clearModels(models []*modelEntry) error {
-	token, err := client.configuration.getBearerToken()
+// Removes models from the Azure Digital Twin instance. The levels are deleted in order, as produced by deletionLevels,
+// and the models within a level are deleted at the same time using up to concurrency requests. If a model cannot be
+// deleted then the remaining models in its level are abandoned and no further levels are deleted
+func (client *client) clearModels(ctx context.Context, levels [][]*modelEntry, concurrency int) error {
+	total := 0
+	for _, level := range levels {
+		total += len(level)
+	}
+
+	if client.dryRun {
+		for i, level := range levels {
+			for _, entry := range level {
+				printDryRunRequest("DELETE", client.getModelUrl(&entry.modelId, nil), fmt.Sprintf("level %d/%d", i+1, len(levels)))
+			}
+		}
+		return nil
+	}
+
+	token, err := client.configuration.getBearerToken(ctx)
 	if err != nil {
 		return err
 	}
 
-	for i, entry := range models {
-		endpoint := client.getModelUrl(&entry.modelId, nil)
+	if concurrency < 1 {
+		concurrency = 1
+	}
 
-		req, _ := http.NewRequest("DELETE", endpoint, nil)
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.Token))
+	deleted := 0
+	for i, level := range levels {
+		log.Printf("Deleting level %d/%d (%d model(s))", i+1, len(levels), len(level))
 
-		log.Printf("Deleting entry %d/%d: %s", i+1, len(models), entry.modelId)
-		resp, err := client.httpClient.Do(req)
-		if err != nil {
-			return fmt.Errorf("unable to delete model %s\n%s", entry.modelId, err)
-		} else if resp.StatusCode != 204 {
-			return handleResponseError(resp)
+		count, err := client.deleteLevel(ctx, level, concurrency, token)
+		deleted += count
+		if ctx.Err() != nil {
+			return cancellationError(ctx, fmt.Sprintf("deleting %d/%d models", deleted, total))
+		} else if err != nil {
+			return fmt.Errorf("%s\n%d/%d models were deleted before the failure", err, deleted, total)
 		}
 	}
 
 	return nil
 }
 
-// Uploads all models to the Azure Digital Twin instance
-func (client *client) uploadModels(models []*modelEntry) error {
-	var batches [][]*modelEntry
-	modelCount := len(models)
+// Deletes the models in a single level using a pool of workers, returning the number of models deleted. The first
+// failure stops any more models in the level from being deleted, while deletes which are already in flight are allowed
+// to finish so that the count includes every model removed from the instance
+func (client *client) deleteLevel(ctx context.Context, level []*modelEntry, concurrency int, token *azcore.AccessToken) (int, error) {
+	if concurrency > len(level) {
+		concurrency = len(level)
+	}
 
-	// Sort the models into batches based on the limits
-	if modelCount < maxModelsApiLimit {
-		batches = make([][]*modelEntry, 1)
-		batches[0] = models
-	} else {
-		batchCount := int(math.Ceil(float64(modelCount) / float64(maxModelsPerBatch)))
-		batches = make([][]*modelEntry, batchCount)
-		for i := 0; i < batchCount; i++ {
-			start := i * maxModelsPerBatch
-			end := start + maxModelsPerBatch
-			if end > modelCount {
-				end = modelCount
+	var waitGroup sync.WaitGroup
+	var mutex sync.Mutex
+	var firstErr error
+	deleted := 0
+
+	// Closed on the first failure to stop any more models being handed to the workers
+	failed := make(chan struct{})
+
+	work := make(chan *modelEntry)
+	for i := 0; i < concurrency; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for entry := range work {
+				// A model handed over as the failure happened is not deleted
+				select {
+				case <-failed:
+					continue
+				default:
+				}
+
+				err := client.deleteModel(ctx, entry, token)
+
+				mutex.Lock()
+				if err == nil {
+					deleted++
+				} else if firstErr == nil {
+					firstErr = err
+					close(failed)
+				}
+				mutex.Unlock()
 			}
-			batches[i] = models[start:end]
+		}()
+	}
+
+feed:
+	for _, entry := range level {
+		select {
+		case work <- entry:
+		case <-failed:
+			break feed
+		case <-ctx.Done():
+			break feed
 		}
 	}
+	close(work)
+	waitGroup.Wait()
 
-	token, err := client.configuration.getBearerToken()
+	return deleted, firstErr
+}
+
+// Deletes a single model from the Azure Digital Twin instance. A model which has already been removed is not treated
+// as an error
+func (client *client) deleteModel(ctx context.Context, entry *modelEntry, token *azcore.AccessToken) error {
+	log.Printf("Deleting model: %s", entry.modelId)
+
+	resp, err := client.send(ctx, http.MethodDelete, client.getModelUrl(&entry.modelId, nil), nil, "", token)
 	if err != nil {
-		return err
+		return fmt.Errorf("unable to delete model %s\n%s", entry.modelId, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == 404 {
+		// The model may have been removed by an earlier attempt which was retried
+		log.Printf("Model %s has already been removed", entry.modelId)
+	} else if resp.StatusCode != 204 {
+		return fmt.Errorf("unable to delete model %s: %s", entry.modelId, handleResponseError(resp))
+	}
+
+	return nil
+}
+
+// Uploads each batch of models to the Azure Digital Twin instance in order. If given, committed is called with the index
+// of each batch once it has been uploaded successfully, and a failure from it stops the upload
+func (client *client) uploadBatches(ctx context.Context, batches [][]*modelEntry, committed func(batch int) error) error {
+	var token *azcore.AccessToken
+	var err error
+	if !client.dryRun {
+		token, err = client.configuration.getBearerToken(ctx)
+		if err != nil {
+			return err
+		}
 	}
 
 	endpoint := client.getModelUrl(nil, nil)
@@ -167,22 +272,110 @@ func (client *client) uploadModels(models []*modelEntry) error {
 			return fmt.Errorf("unable to convert batch to JSON: %s", err)
 		}
 
-		req, _ := http.NewRequest("POST", endpoint, bytes.NewBuffer(requestBody))
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.Token))
-		req.Header.Set("Content-Type", "application/json")
+		if client.dryRun {
+			printDryRunRequest("POST", endpoint, fmt.Sprintf("batch %d/%d, %d model(s), %d bytes", i+1, len(batches), len(batches[i]), len(requestBody)))
+			for _, entry := range batches[i] {
+				fmt.Printf("    %s\n", entry.modelId)
+			}
+			continue
+		}
 
 		log.Printf("Uploading batch %d/%d", i+1, len(batches))
-		resp, err := client.httpClient.Do(req)
-		if err != nil {
+		resp, err := client.send(ctx, http.MethodPost, endpoint, requestBody, "application/json", token)
+		if ctx.Err() != nil {
+			return cancellationError(ctx, fmt.Sprintf("uploading %d/%d batches", i, len(batches)))
+		} else if err != nil {
 			return fmt.Errorf("unable to upload models: %s", err)
 		} else if resp.StatusCode != 201 {
 			return handleResponseError(resp)
 		}
+		_ = resp.Body.Close()
+
+		if committed != nil {
+			if err = committed(i); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Marks each of the models in the Azure Digital Twin instance as decommissioned, which prevents new twins from being
+// created using them
+func (client *client) decommissionModels(ctx context.Context, modelIds []string) error {
+	if client.dryRun {
+		for i := range modelIds {
+			printDryRunRequest("PATCH", client.getModelUrl(&modelIds[i], nil), "decommissioned: true")
+		}
+		return nil
+	}
+
+	token, err := client.configuration.getBearerToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	requestBody := []byte(`[{"op":"replace","path":"/decommissioned","value":true}]`)
+
+	for i := range modelIds {
+		endpoint := client.getModelUrl(&modelIds[i], nil)
+
+		log.Printf("Decommissioning entry %d/%d: %s", i+1, len(modelIds), modelIds[i])
+		resp, err := client.send(ctx, http.MethodPatch, endpoint, requestBody, "application/json-patch+json", token)
+		if ctx.Err() != nil {
+			return cancellationError(ctx, fmt.Sprintf("decommissioning %d/%d models", i, len(modelIds)))
+		} else if err != nil {
+			return fmt.Errorf("unable to decommission model %s\n%s", modelIds[i], err)
+		} else if resp.StatusCode != 204 {
+			return handleResponseError(resp)
+		}
+		_ = resp.Body.Close()
 	}
 
 	return nil
 }
 
+// Counts the twins in the Azure Digital Twin instance which were created using the given model, not including twins
+// of models which extend it. If the credentials are not allowed to query twins then errTwinQueryForbidden is returned
+func (client *client) countTwins(ctx context.Context, modelId string, token *azcore.AccessToken) (int, error) {
+	endpoint := client.configuration.endpoint
+	endpoint.Path = "/query"
+	endpoint.RawQuery = url.Values{"api-version": []string{apiVersion}}.Encode()
+
+	query := fmt.Sprintf("SELECT COUNT() FROM DIGITALTWINS WHERE $metadata.$model = '%s'", strings.ReplaceAll(modelId, "'", "\\'"))
+	requestBody, err := json.Marshal(map[string]string{"query": query})
+	if err != nil {
+		return 0, err
+	}
+
+	log.Printf("Counting twins of model %s", modelId)
+	resp, err := client.send(ctx, http.MethodPost, endpoint.String(), requestBody, "application/json", token)
+	if ctx.Err() != nil {
+		return 0, cancellationError(ctx, fmt.Sprintf("counting twins of model %s", modelId))
+	} else if err != nil {
+		return 0, fmt.Errorf("unable to count twins of model %s\n%s", modelId, err)
+	} else if resp.StatusCode == http.StatusForbidden {
+		_ = resp.Body.Close()
+		return 0, errTwinQueryForbidden
+	} else if resp.StatusCode != 200 {
+		return 0, handleResponseError(resp)
+	}
+
+	var result struct {
+		Value []struct {
+			Count int `json:"COUNT"`
+		} `json:"value"`
+	}
+	respContent, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err := json.Unmarshal(respContent, &result); err != nil || len(result.Value) == 0 {
+		return 0, fmt.Errorf("unable to read the count of twins of model %s from the response", modelId)
+	}
+
+	return result.Value[0].Count, nil
+}
+
 // Converts a batch of modelEntry objects to an array of jsonObject items which can be converted into
 // a JSON body
 func batchToJsonArray(batch []*modelEntry) []jsonObject {
@@ -193,6 +386,71 @@ func batchToJsonArray(batch []*modelEntry) []jsonObject {
 	return content
 }
 
+// Sends a request to the Azure Digital Twin instance, retrying it according to the retry policy of the configuration
+// if it is throttled or fails with a transient error. The request, and any wait between retries, is abandoned when the
+// context is cancelled. The caller is responsible for closing the body of the response
+func (client *client) send(ctx context.Context, method string, endpoint string, body []byte, contentType string, token *azcore.AccessToken) (*http.Response, error) {
+	policy := client.configuration.retry
+
+	for attempt := 0; ; attempt++ {
+		var bodyReader io.Reader
+		if body != nil {
+			bodyReader = bytes.NewReader(body)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, endpoint, bodyReader)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.Token))
+		req.Header.Set("Accept", "application/json")
+		if len(contentType) > 0 {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		resp, err := client.httpClient.Do(req)
+		if attempt >= policy.MaxRetries || !shouldRetry(method, resp, err) {
+			return resp, err
+		}
+
+		delay := policy.delay(attempt, resp)
+		if err != nil {
+			log.Printf("Request %s %s failed (%s), retrying in %s (retry %d/%d)", method, endpoint, err, delay, attempt+1, policy.MaxRetries)
+		} else {
+			log.Printf("Request %s %s returned status %d, retrying in %s (retry %d/%d)", method, endpoint, resp.StatusCode, delay, attempt+1, policy.MaxRetries)
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Creates an error for an operation which was stopped because the context was cancelled or timed out, describing how
+// far the operation got before it was stopped
+func cancellationError(ctx context.Context, progress string) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s: %w", progress, ctx.Err())
+	}
+	return fmt.Errorf("cancelled after %s: %w", progress, ctx.Err())
+}
+
+// Writes out a request which would have been sent if the client was not in dry-run mode
+func printDryRunRequest(method string, endpoint string, description string) {
+	if len(description) == 0 {
+		fmt.Printf("[dry-run] %s %s\n", method, endpoint)
+	} else {
+		fmt.Printf("[dry-run] %s %s (%s)\n", method, endpoint, description)
+	}
+}
+
 // In the event of an API error response, this handles it at returns an error detailing the error
 func handleResponseError(resp *http.Response) error {
 	respContent, err := io.ReadAll(resp.Body)
@@ -0,0 +1,346 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Counts the requests received by a test server, which may be handling requests concurrently
+type requestCounter struct {
+	mutex sync.Mutex
+	value int
+}
+
+// Gets the number of requests received
+func (counter *requestCounter) count() int {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	return counter.value
+}
+
+// Creates a client for a test server which counts the requests it receives
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*client, *requestCounter) {
+	counter := &requestCounter{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		counter.mutex.Lock()
+		counter.value++
+		counter.mutex.Unlock()
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	endpoint, _ := url.Parse(server.URL)
+	return newClient(&twinConfiguration{endpoint: *endpoint, credential: testCredential{}}), counter
+}
+
+// testCredential returns a fixed token, so that requests can be made to a test server
+type testCredential struct{}
+
+// GetToken returns a token which expires in an hour
+func (testCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// fakeInstance acts as the models API of an Azure Digital Twin instance, recording the changes made to it
+type fakeInstance struct {
+	mutex      sync.Mutex
+	models     []digitalTwinsModelData // The models in the instance, in the order they were created
+	uploads    int                     // The number of upload requests received
+	failUpload int                     // The upload request which fails, counting from 1, with 0 meaning none fail
+	partial    int                     // The number of models in the failing upload request which are created before it fails
+	failDelete string                  // The id of a model which cannot be deleted
+	removed    string                  // The id of a model which is listed but has already been removed, so cannot be found when deleted
+	deleted    []string                // The ids of the models deleted, in the order they were deleted
+	patched    []string                // The ids of the models decommissioned, in the order they were decommissioned
+}
+
+// Creates a client for a fake instance holding the models
+func newFakeInstanceClient(t *testing.T, instance *fakeInstance, models ...*modelEntry) *client {
+	for _, entry := range models {
+		instance.models = append(instance.models, digitalTwinsModelData{Id: entry.modelId, Model: entry.model})
+	}
+
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		instance.mutex.Lock()
+		defer instance.mutex.Unlock()
+
+		id := strings.TrimPrefix(r.URL.Path, "/models/")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/models":
+			_ = json.NewEncoder(w).Encode(pagedDigitalTwinsModelDataCollection{Value: instance.models})
+		case r.Method == http.MethodPost && r.URL.Path == "/models":
+			instance.uploads++
+			var batch []jsonObject
+			content, _ := io.ReadAll(r.Body)
+			_ = json.Unmarshal(content, &batch)
+
+			failed := instance.uploads == instance.failUpload
+			if failed {
+				batch = batch[:instance.partial]
+			}
+
+			for _, model := range batch {
+				instance.models = append(instance.models, digitalTwinsModelData{Id: model["@id"].(string), Model: model})
+			}
+
+			if failed {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error": {"code": "DTDLParserError"}}`))
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPatch:
+			for i, model := range instance.models {
+				if model.Id == id {
+					instance.models[i].Decommissioned = true
+					instance.patched = append(instance.patched, id)
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodDelete:
+			if id == instance.removed {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			if id == instance.failDelete {
+				w.WriteHeader(http.StatusConflict)
+				_, _ = w.Write([]byte(`{"error": {"code": "ModelReferencesNotDeleted"}}`))
+				return
+			}
+
+			for i, model := range instance.models {
+				if model.Id == id {
+					instance.models = append(instance.models[:i], instance.models[i+1:]...)
+					instance.deleted = append(instance.deleted, id)
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	return client
+}
+
+// Gets the ids of the models in the fake instance, separated by commas
+func (instance *fakeInstance) modelIds() string {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+
+	ids := make([]string, len(instance.models))
+	for i, model := range instance.models {
+		ids[i] = model.Id
+	}
+	return strings.Join(ids, ",")
+}
+
+func Test_client_dryRun(t *testing.T) {
+	client, requestCount := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	client.dryRun = true
+
+	models := []*modelEntry{
+		newTestEntry(t, `{"@id": "dtmi:test:a;1", "@type": "Interface"}`),
+		newTestEntry(t, `{"@id": "dtmi:test:b;1", "@type": "Interface"}`),
+	}
+
+	if err := client.uploadBatches(context.Background(), [][]*modelEntry{models}, nil); err != nil {
+		t.Errorf("Expected dry-run upload to succeed, but got error: %s", err)
+	}
+
+	if err := client.decommissionModels(context.Background(), []string{"dtmi:test:a;1"}); err != nil {
+		t.Errorf("Expected dry-run decommission to succeed, but got error: %s", err)
+	}
+
+	if err := client.clearModels(context.Background(), [][]*modelEntry{models}, 4); err != nil {
+		t.Errorf("Expected dry-run clear to succeed, but got error: %s", err)
+	}
+
+	if requestCount.count() != 0 {
+		t.Errorf("Expected no requests to be sent in dry-run mode, but %d were sent", requestCount.count())
+	}
+}
+
+func Test_client_deleteLevel(t *testing.T) {
+	var mutex sync.Mutex
+	active, maxActive := 0, 0
+
+	client, requestCount := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mutex.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mutex.Lock()
+		active--
+		mutex.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	level := make([]*modelEntry, 0)
+	for i := 0; i < 8; i++ {
+		level = append(level, newTestEntry(t, fmt.Sprintf(`{"@id": "dtmi:test:model%d;1", "@type": "Interface"}`, i)))
+	}
+
+	deleted, err := client.deleteLevel(context.Background(), level, 3, &azcore.AccessToken{Token: "token"})
+	if err != nil {
+		t.Fatalf("Expected the level to be deleted, but got error: %s", err)
+	}
+
+	if deleted != len(level) || requestCount.count() != len(level) {
+		t.Errorf("Expected %d models to be deleted, but %d were deleted using %d requests", len(level), deleted, requestCount.count())
+	}
+
+	if maxActive > 3 {
+		t.Errorf("Expected at most 3 concurrent requests, but there were %d", maxActive)
+	}
+}
+
+func Test_client_deleteLevelCountsInFlightDeletes(t *testing.T) {
+	client, requestCount := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "model0") {
+			// Fail once every delete has started, while the others are still in flight
+			time.Sleep(10 * time.Millisecond)
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"error": {"code": "ModelReferencesNotDeleted"}}`))
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	level := make([]*modelEntry, 0)
+	for i := 0; i < 4; i++ {
+		level = append(level, newTestEntry(t, fmt.Sprintf(`{"@id": "dtmi:test:model%d;1", "@type": "Interface"}`, i)))
+	}
+
+	deleted, err := client.deleteLevel(context.Background(), level, 4, &azcore.AccessToken{Token: "token"})
+	if err == nil || !strings.Contains(err.Error(), "dtmi:test:model0;1") {
+		t.Errorf("Expected an error for the model which could not be deleted, but got: %v", err)
+	}
+
+	if deleted != 3 || requestCount.count() != 4 {
+		t.Errorf("Expected the 3 deletes in flight to finish and be counted, but %d were counted from %d requests", deleted, requestCount.count())
+	}
+}
+
+func Test_client_deleteLevelStopsOnFailure(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "model0") {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"error": {"code": "ModelReferencesNotDeleted"}}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	level := make([]*modelEntry, 0)
+	for i := 0; i < 20; i++ {
+		level = append(level, newTestEntry(t, fmt.Sprintf(`{"@id": "dtmi:test:model%d;1", "@type": "Interface"}`, i)))
+	}
+
+	deleted, err := client.deleteLevel(context.Background(), level, 1, &azcore.AccessToken{Token: "token"})
+	if err == nil || !strings.Contains(err.Error(), "dtmi:test:model0;1") {
+		t.Errorf("Expected an error for the model which could not be deleted, but got: %v", err)
+	}
+
+	if deleted != 0 {
+		t.Errorf("Expected no models to be deleted after the failure, but %d were deleted", deleted)
+	}
+}
+
+func Test_client_decommissionModels(t *testing.T) {
+	var mutex sync.Mutex
+	requests := make([]string, 0)
+
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		content, _ := io.ReadAll(r.Body)
+
+		mutex.Lock()
+		requests = append(requests, fmt.Sprintf("%s %s %s %s", r.Method, r.URL.Path, r.Header.Get("Content-Type"), content))
+		mutex.Unlock()
+
+		switch {
+		case strings.Contains(r.URL.Path, "missing"):
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error": {"code": "ModelNotFound"}}`))
+		case strings.Contains(r.URL.Path, "ok"):
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	err := client.decommissionModels(context.Background(), []string{"dtmi:test:a;1", "dtmi:test:b;1"})
+	if err != nil {
+		t.Fatalf("Expected the models to be decommissioned, but got error: %s", err)
+	}
+
+	expected := []string{
+		`PATCH /models/dtmi:test:a;1 application/json-patch+json [{"op":"replace","path":"/decommissioned","value":true}]`,
+		`PATCH /models/dtmi:test:b;1 application/json-patch+json [{"op":"replace","path":"/decommissioned","value":true}]`,
+	}
+	if strings.Join(requests, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected requests:\n%s\nbut got:\n%s", strings.Join(expected, "\n"), strings.Join(requests, "\n"))
+	}
+
+	for _, modelId := range []string{"dtmi:test:missing;1", "dtmi:test:ok;1"} {
+		err = client.decommissionModels(context.Background(), []string{modelId})
+		if err == nil || !strings.Contains(err.Error(), "non-success status code") {
+			t.Errorf("Expected an error decommissioning %s, but got: %v", modelId, err)
+		}
+	}
+}
+
+func Test_client_listModels(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			_, _ = w.Write([]byte(`{"value": [{"id": "dtmi:test:b;1", "decommissioned": true, "uploadTime": "2022-02-01T10:00:00Z",
+				"model": {"@id": "dtmi:test:b;1", "@type": "Interface"}}]}`))
+			return
+		}
+
+		nextLink := fmt.Sprintf("http://%s/models?page=2", r.Host)
+		_, _ = fmt.Fprintf(w, `{"nextLink": %q, "value": [{"id": "dtmi:test:a;1", "decommissioned": false, "uploadTime": "2022-01-01T10:00:00Z",
+			"model": {"@id": "dtmi:test:a;1", "@type": "Interface"}}]}`, nextLink)
+	})
+
+	models, err := client.listModels(context.Background())
+	if err != nil {
+		t.Fatalf("Expected the models to be listed, but got error: %s", err)
+	}
+
+	if len(models) != 2 || models[0].decommissioned || models[0].uploadTime != "2022-01-01T10:00:00Z" ||
+		!models[1].decommissioned || models[1].uploadTime != "2022-02-01T10:00:00Z" {
+		t.Fatalf("Unexpected models listed: %+v", models)
+	}
+
+	var out strings.Builder
+	printModelList(&out, models)
+	if out.String() != "dtmi:test:a;1\ndtmi:test:b;1 (decommissioned)\n" {
+		t.Errorf("Unexpected list output: %q", out.String())
+	}
+}
@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrModelsDiffer is returned by DiffModels when the local models and those in the Azure Digital Twin instance are not
+// the same
+var ErrModelsDiffer = errors.New("the local models differ from those in the digital twin instance")
+
+// ModelConflictError is returned when models exist in the Azure Digital Twin instance with the same id as a local model
+// but with different content. As models are immutable they cannot be replaced, and need to be given a new version
+type ModelConflictError struct {
+	ModelIds    []string          // The ids of the models which are in conflict
+	Suggestions map[string]string // For each model in conflict, the next version of its id which is not in the instance
+}
+
+// Error returns the string representation of the error, listing each of the models in conflict
+func (e *ModelConflictError) Error() string {
+	var builder strings.Builder
+	_, _ = fmt.Fprintf(&builder, "%d model(s) already exist in the digital twin instance with different content, models are immutable and so must be given a new version:", len(e.ModelIds))
+	for _, id := range e.ModelIds {
+		builder.WriteString("\n  ")
+		builder.WriteString(id)
+		if suggestion, ok := e.Suggestions[id]; ok {
+			_, _ = fmt.Fprintf(&builder, " (upload the changes as %s)", suggestion)
+		}
+	}
+	return builder.String()
+}
+
+// modelDiff holds the result of comparing a set of local models with those in an Azure Digital Twin instance
+type modelDiff struct {
+	OnlyLocal  []string `json:"onlyLocal"`  // Models which only exist locally, and would be created
+	OnlyRemote []string `json:"onlyRemote"` // Models which only exist in the instance, and would be orphaned
+	Identical  []string `json:"identical"`  // Models which exist in both with the same content
+	Changed    []string `json:"changed"`    // Models which exist in both, but where the content is different
+}
+
+// Checks if the local and remote models are not the same
+func (diff *modelDiff) hasDifferences() bool {
+	return len(diff.OnlyLocal) > 0 || len(diff.OnlyRemote) > 0 || len(diff.Changed) > 0
+}
+
+// Returns a ModelConflictError if any models have the same id but different content
+func (diff *modelDiff) conflictError() error {
+	if len(diff.Changed) == 0 {
+		return nil
+	}
+	remoteIds := make([]string, 0, len(diff.OnlyRemote)+len(diff.Identical)+len(diff.Changed))
+	remoteIds = append(remoteIds, diff.OnlyRemote...)
+	remoteIds = append(remoteIds, diff.Identical...)
+	remoteIds = append(remoteIds, diff.Changed...)
+
+	suggestions := make(map[string]string)
+	for _, id := range diff.Changed {
+		suggestions[id] = nextModelId(id, remoteIds)
+	}
+
+	return &ModelConflictError{ModelIds: diff.Changed, Suggestions: suggestions}
+}
+
+// Compares the local models with the remote models, classifying each model id by where it exists and if the content
+// is the same. Each collection of ids is sorted
+func diffModels(local []*modelEntry, remote []*modelEntry) modelDiff {
+	diff := modelDiff{
+		OnlyLocal:  make([]string, 0),
+		OnlyRemote: make([]string, 0),
+		Identical:  make([]string, 0),
+		Changed:    make([]string, 0),
+	}
+
+	remoteModels := make(map[string]*modelEntry)
+	for _, entry := range remote {
+		remoteModels[entry.modelId] = entry
+	}
+
+	localModels := make(map[string]*modelEntry)
+	for _, entry := range local {
+		if _, ok := localModels[entry.modelId]; ok {
+			continue
+		}
+		localModels[entry.modelId] = entry
+
+		remoteEntry, ok := remoteModels[entry.modelId]
+		if !ok {
+			diff.OnlyLocal = append(diff.OnlyLocal, entry.modelId)
+		} else if entry.model.equals(remoteEntry.model) {
+			diff.Identical = append(diff.Identical, entry.modelId)
+		} else {
+			diff.Changed = append(diff.Changed, entry.modelId)
+		}
+	}
+
+	for _, entry := range remote {
+		if _, ok := localModels[entry.modelId]; !ok {
+			diff.OnlyRemote = append(diff.OnlyRemote, entry.modelId)
+		}
+	}
+
+	for _, ids := range [][]string{diff.OnlyLocal, diff.OnlyRemote, diff.Identical, diff.Changed} {
+		sort.Strings(ids)
+	}
+
+	return diff
+}
+
+// Writes the diff in a human-readable form
+func (diff *modelDiff) printText() {
+	for _, id := range diff.OnlyLocal {
+		fmt.Printf("+ %s\n", id)
+	}
+	for _, id := range diff.OnlyRemote {
+		fmt.Printf("- %s\n", id)
+	}
+	for _, id := range diff.Changed {
+		fmt.Printf("! %s\n", id)
+	}
+	for _, id := range diff.Identical {
+		fmt.Printf("= %s\n", id)
+	}
+
+	fmt.Println()
+	fmt.Printf("%d only local (would be created), %d only in the instance (would be orphaned), %d identical, %d changed\n",
+		len(diff.OnlyLocal), len(diff.OnlyRemote), len(diff.Identical), len(diff.Changed))
+
+	if len(diff.Changed) > 0 {
+		fmt.Println("Models are immutable, changed models must be given a new version before they can be uploaded")
+	}
+}
+
+// Writes the diff as a JSON document
+func (diff *modelDiff) printJson() error {
+	content, err := json.MarshalIndent(diff, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(content))
+	return nil
+}
@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func newTestEntry(t *testing.T, content string) *modelEntry {
+	var object jsonObject
+	if err := json.Unmarshal([]byte(content), &object); err != nil {
+		t.Fatalf("Unable to parse test model: %s", err)
+	}
+
+	entry, err := newModelEntry(object)
+	if err != nil {
+		t.Fatalf("Unable to create test model: %s", err)
+	}
+	return entry
+}
+
+func Test_diffModels(t *testing.T) {
+	local := []*modelEntry{
+		newTestEntry(t, `{"@id": "dtmi:test:a;1", "@type": "Interface", "displayName": "A", "contents": [{"@type": "Property", "name": "p", "schema": "string"}]}`),
+		newTestEntry(t, `{"@id": "dtmi:test:b;1", "@type": "Interface", "displayName": "B"}`),
+		newTestEntry(t, `{"@id": "dtmi:test:c;1", "@type": "Interface", "displayName": "C"}`),
+	}
+
+	remote := []*modelEntry{
+		newTestEntry(t, `{
+			"contents": [{"schema": "string", "name": "p", "@type": "Property"}],
+			"displayName": "A",
+			"@type": "Interface",
+			"@id": "dtmi:test:a;1"
+		}`),
+		newTestEntry(t, `{"@id": "dtmi:test:b;1", "@type": "Interface", "displayName": "Changed B"}`),
+		newTestEntry(t, `{"@id": "dtmi:test:d;1", "@type": "Interface", "displayName": "D"}`),
+	}
+
+	diff := diffModels(local, remote)
+
+	expected := modelDiff{
+		OnlyLocal:  []string{"dtmi:test:c;1"},
+		OnlyRemote: []string{"dtmi:test:d;1"},
+		Identical:  []string{"dtmi:test:a;1"},
+		Changed:    []string{"dtmi:test:b;1"},
+	}
+
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Expected %+v, but got %+v", expected, diff)
+	}
+
+	if !diff.hasDifferences() {
+		t.Errorf("Expected the diff to report differences")
+	}
+}
+
+func Test_diffModels_identical(t *testing.T) {
+	local := []*modelEntry{newTestEntry(t, `{"@id": "dtmi:test:a;1", "@type": "Interface"}`)}
+	remote := []*modelEntry{newTestEntry(t, `{"@type": "Interface", "@id": "dtmi:test:a;1"}`)}
+
+	diff := diffModels(local, remote)
+
+	if diff.hasDifferences() {
+		t.Errorf("Expected no differences, but got %+v", diff)
+	}
+}
+
+func Test_modelDiff_conflictError(t *testing.T) {
+	local := []*modelEntry{newTestEntry(t, `{"@id": "dtmi:test:a;1", "@type": "Interface", "displayName": "A"}`)}
+	remote := []*modelEntry{newTestEntry(t, `{"@id": "dtmi:test:a;1", "@type": "Interface", "displayName": "Changed A"}`)}
+
+	diff := diffModels(local, remote)
+	err := diff.conflictError()
+
+	var conflictErr *ModelConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("Expected a ModelConflictError, but got %v", err)
+	}
+
+	if len(conflictErr.ModelIds) != 1 || conflictErr.ModelIds[0] != "dtmi:test:a;1" {
+		t.Errorf("Expected conflict for dtmi:test:a;1, but got %v", conflictErr.ModelIds)
+	}
+}
+
+func Test_filterModels(t *testing.T) {
+	d := ModelDirectory{}
+	_ = d.Set("../testdata/models")
+	models, _ := d.getModels()
+
+	setModelDependencies(models)
+	sorted, _ := sortModels(models)
+
+	// The space model already exists, and so only those which extend it should be uploaded
+	remote := filterModels(models, []string{"dtmi:digitaltwins:testing:core:space;1"})
+	diff := diffModels(models, remote)
+	toUpload := filterModels(sorted, diff.OnlyLocal)
+
+	if len(toUpload) != len(models)-1 {
+		t.Fatalf("Expected %d models to upload, but got %d", len(models)-1, len(toUpload))
+	}
+
+	var roomIndex, meetingRoomIndex int
+	for i, entry := range toUpload {
+		if entry.modelId == "dtmi:digitaltwins:testing:core:space;1" {
+			t.Fatalf("The space model should not be uploaded as it already exists")
+		} else if entry.modelId == "dtmi:digitaltwins:testing:core:room;1" {
+			roomIndex = i
+		} else if entry.modelId == "dtmi:digitaltwins:testing:core:meetingroom;1" {
+			meetingRoomIndex = i
+		}
+	}
+
+	if roomIndex > meetingRoomIndex {
+		t.Errorf("Expected room model to be before the meeting room model. room [%d], meeting room [%d]", roomIndex, meetingRoomIndex)
+	}
+}
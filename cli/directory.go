@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
@@ -14,7 +16,8 @@ import (
 // Defines a byte order mark
 var byteOrderMark = []byte{0xEF, 0xBB, 0xBF}
 
-// ModelDirectory defines a location on disk where models may be uploaded from
+// ModelDirectory defines a location where models may be read from, which is either a directory on disk, a zip or gzip
+// compressed tar archive, or stdin when the path is "-"
 type ModelDirectory struct {
 	Path string
 }
@@ -30,68 +33,188 @@ func (directory *ModelDirectory) Set(path string) error {
 		return fmt.Errorf("a path must be specified")
 	}
 
+	if path == StdinSource {
+		*directory = ModelDirectory{Path: path}
+		return nil
+	}
+
 	folderInfo, err := os.Stat(path)
 
 	if err != nil && os.IsNotExist(err) {
 		return fmt.Errorf("the specified path does not exist")
 	} else if err != nil {
 		return fmt.Errorf("an error occured validating the path: %s", err)
-	} else if !folderInfo.IsDir() {
-		return fmt.Errorf("the specified path is not a directory")
+	} else if !folderInfo.IsDir() && sourceKindOf(path) == directorySource {
+		return fmt.Errorf("the specified path is not a directory or a .zip, .tar.gz or .tgz archive")
+	} else if folderInfo.IsDir() && sourceKindOf(path) != directorySource {
+		return fmt.Errorf("the specified path is a directory, but its name is that of an archive")
 	}
 
 	*directory = ModelDirectory{Path: path}
 	return nil
 }
 
-// Gets all models found recursively under the defined path
-func (directory *ModelDirectory) getModels() ([]*modelEntry, error) {
-	models := make([]*modelEntry, 0)
+// OutputDirectory defines a directory on disk where models are written to. Unlike a ModelDirectory, stdin and archives
+// are not accepted as models cannot be written to them
+type OutputDirectory struct {
+	ModelDirectory
+}
+
+// Set creates a new instance of the OutputDirectory type, rejecting paths which are not a directory
+func (directory *OutputDirectory) Set(path string) error {
+	if kind := sourceKindOf(path); kind == stdinSource {
+		return fmt.Errorf("models cannot be written to stdin, a directory must be specified")
+	} else if kind != directorySource {
+		return fmt.Errorf("models cannot be written to an archive, a directory must be specified")
+	}
+
+	return directory.ModelDirectory.Set(path)
+}
+
+// Checks if the models are read from a directory on disk, rather than an archive or stdin
+func (directory *ModelDirectory) isDirectory() bool {
+	return sourceKindOf(directory.Path) == directorySource
+}
+
+// modelFile holds the content of a model file found in a ModelDirectory
+type modelFile struct {
+	path    string // The path of the file
+	content []byte // The content of the file, with any byte order mark removed
+	err     error  // Any error which occurred reading the file
+}
 
-	err := filepath.Walk(directory.Path, func(path string, info fs.FileInfo, err error) error {
+// Reads all the JSON, newline delimited JSON, and DTDL files found recursively under the defined path. Files in an
+// archive are given paths within the archive, such as "models.zip/dtmi/room.json", and models read from stdin are
+// given the path "<stdin>"
+func (directory *ModelDirectory) readFiles() ([]modelFile, error) {
+	files := make([]modelFile, 0)
+
+	source, err := openSource(directory.Path, os.Stdin)
+	if err != nil {
+		return files, err
+	}
+
+	err = fs.WalkDir(source, ".", func(name string, entry fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
-		extension := strings.ToLower(filepath.Ext(info.Name()))
+		extension := strings.ToLower(path.Ext(entry.Name()))
 
-		// Only inspect files which are JSON or DTDL files
-		if !info.IsDir() && (extension == ".json" || extension == ".dtdl") {
-			fileContent, err := os.ReadFile(path)
-			if err != nil {
-				log.Printf("Unable to open file '%s', %s", path, err)
-				return nil
-			}
+		// Only inspect files which are JSON, newline delimited JSON, or DTDL files
+		if !entry.IsDir() && (extension == ".json" || extension == ".ndjson" || extension == ".jsonl" || extension == ".dtdl") {
+			fileContent, err := fs.ReadFile(source, name)
 
 			// Strip the byte order mark if it exists
-			if bytes.Compare(fileContent[0:3], byteOrderMark) == 0 {
-				fileContent = bytes.TrimPrefix(fileContent, byteOrderMark)
+			fileContent = bytes.TrimPrefix(fileContent, byteOrderMark)
+
+			files = append(files, modelFile{path: directory.filePath(name), content: fileContent, err: err})
+		}
+
+		return nil
+	})
+
+	return files, err
+}
+
+// Gets the path used to report a file found in the source
+func (directory *ModelDirectory) filePath(name string) string {
+	if directory.Path == StdinSource {
+		return "<stdin>"
+	}
+	return filepath.Join(directory.Path, filepath.FromSlash(name))
+}
+
+// modelDocument is a single model decoded from a model file, which may hold more than one model
+type modelDocument struct {
+	value   interface{} // The decoded model, which should be a JSON object
+	index   int         // The position of the model in the file, starting from zero
+	start   int64       // The byte offset in the file of the JSON value containing the model
+	pointer string      // JSON pointer to the model within the value containing it
+}
+
+// Decodes the models held in the content of a model file. The file can hold a single model, a top level array of
+// models, or newline delimited JSON with a model (or array of models) on each line. If the content is not valid JSON
+// then the models decoded before the error are returned along with the error
+func decodeModelDocuments(content []byte) ([]modelDocument, error) {
+	documents := make([]modelDocument, 0)
+	decoder := json.NewDecoder(bytes.NewReader(content))
+
+	for {
+		start := decoder.InputOffset()
+
+		var value interface{}
+		err := decoder.Decode(&value)
+		if err == io.EOF {
+			return documents, nil
+		} else if err != nil {
+			return documents, err
+		}
+
+		// Move the start past any whitespace so that it points at the value itself
+		for start < int64(len(content)) && strings.ContainsRune(" \t\r\n", rune(content[start])) {
+			start++
+		}
+
+		if items, ok := value.([]interface{}); ok {
+			for i, item := range items {
+				documents = append(documents, modelDocument{value: item, index: len(documents), start: start, pointer: fmt.Sprintf("/%d", i)})
 			}
+		} else {
+			documents = append(documents, modelDocument{value: value, index: len(documents), start: start})
+		}
+	}
+}
 
-			// Read the contents of the file and create a new modelEntry for it
-			var jsonContent jsonObject
-			err = json.Unmarshal(fileContent, &jsonContent)
-			if err != nil {
-				log.Printf("Ignoring gile '%s' as it does not contain valid json (%s): %s", path, fileContent[0:2], err)
-				return nil
+// Gets all models found recursively under the defined path
+func (directory *ModelDirectory) getModels() ([]*modelEntry, error) {
+	models := make([]*modelEntry, 0)
+
+	files, err := directory.readFiles()
+	if err != nil {
+		return models, err
+	}
+
+	for _, file := range files {
+		if file.err != nil {
+			log.Printf("Unable to open file '%s', %s", file.path, file.err)
+			continue
+		}
+
+		// Read the contents of the file and create a new modelEntry for each model in it
+		documents, err := decodeModelDocuments(file.content)
+		if err != nil {
+			log.Printf("Ignoring file '%s' as it does not contain valid json: %s", file.path, err)
+			continue
+		}
+
+		for _, document := range documents {
+			location := fmt.Sprintf("file '%s'", file.path)
+			if len(documents) > 1 {
+				location = fmt.Sprintf("model %d in file '%s'", document.index+1, file.path)
 			}
 
-			_, ok := jsonContent["@id"]
+			jsonContent, ok := document.value.(map[string]interface{})
 			if !ok {
-				_, ok = jsonContent["id"]
+				log.Printf("Ignoring %s as it is not a json object", location)
+				continue
 			}
-			if !ok {
-				log.Printf("Ignoring file '%s' as it does not contain a valid DTDL document as it is missing the @id/id property", path)
-				return nil
+
+			entry, err := newModelEntry(jsonContent)
+			if err != nil {
+				log.Printf("Ignoring %s as it does not contain a valid DTDL document as it is missing the @id/id property", location)
+				continue
 			}
 
-			entry, _ := newModelEntry(jsonContent)
+			entry.sourcePath = file.path
+			entry.sourceIndex = -1
+			if len(documents) > 1 {
+				entry.sourceIndex = document.index
+			}
 
 			models = append(models, entry)
 		}
+	}
 
-		return nil
-	})
-
-	return models, err
+	return models, nil
 }
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -42,6 +43,31 @@ func TestModelDirectory_Set(t *testing.T) {
 	}
 }
 
+func TestOutputDirectory_Set(t *testing.T) {
+	tests := []struct {
+		name          string
+		outputPath    string
+		expectedError *string
+	}{
+		{name: "Stdin", outputPath: StdinSource, expectedError: errorText("models cannot be written to stdin")},
+		{name: "Archive", outputPath: filepath.Join(t.TempDir(), "models.zip"), expectedError: errorText("models cannot be written to an archive")},
+		{name: "NonexistentPath", outputPath: "../testdata/invalid", expectedError: errorText("the specified path does not exist")},
+		{name: "ValidPath", outputPath: "../testdata/models", expectedError: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := OutputDirectory{}
+			err := d.Set(test.outputPath)
+
+			assertExpectedError(t, err, test.expectedError)
+			if err == nil && d.Path != test.outputPath {
+				t.Errorf("Expected the path to be %s, but got %s", test.outputPath, d.Path)
+			}
+		})
+	}
+}
+
 func TestModelDirectory_String(t *testing.T) {
 	input := "../testdata/models"
 	d := ModelDirectory{}
@@ -86,3 +112,94 @@ func TestModelDirectory_getModels(t *testing.T) {
 		}
 	}
 }
+
+func TestModelDirectory_getModels_multipleModelsPerFile(t *testing.T) {
+	d := ModelDirectory{}
+	_ = d.Set("../testdata/multi")
+
+	models, err := d.getModels()
+	if err != nil {
+		t.Fatalf("Expected a collection of models, but received error: %s", err)
+	}
+
+	expected := map[string]string{
+		"dtmi:digitaltwins:testing:multi:space;1":       "model 1 in ../testdata/multi/ontology.json",
+		"dtmi:digitaltwins:testing:multi:building;1":    "model 2 in ../testdata/multi/ontology.json",
+		"dtmi:digitaltwins:testing:multi:room;1":        "model 1 in ../testdata/multi/rooms.ndjson",
+		"dtmi:digitaltwins:testing:multi:meetingroom;1": "model 2 in ../testdata/multi/rooms.ndjson",
+	}
+
+	if len(models) != len(expected) {
+		t.Errorf("Expected %d models but got %d", len(expected), len(models))
+	}
+
+	for _, m := range models {
+		if location, ok := expected[m.modelId]; !ok {
+			t.Errorf("Unexpected model %s", m.modelId)
+		} else if m.location() != m.modelId+" ("+filepath.FromSlash(location)+")" {
+			t.Errorf("Unexpected location for %s: %s", m.modelId, m.location())
+		}
+	}
+}
+
+func Test_decodeModelDocuments(t *testing.T) {
+	tests := []struct {
+		name             string
+		content          string
+		expectedPointers []string
+		expectedOffset   int64
+	}{
+		{name: "Object", content: `{"@id": "dtmi:test:a;1"}`, expectedPointers: []string{""}},
+		{name: "Array", content: `[{"@id": "dtmi:test:a;1"}, {"@id": "dtmi:test:b;1"}]`, expectedPointers: []string{"/0", "/1"}},
+		{name: "NewlineDelimited", content: "{\"@id\": \"dtmi:test:a;1\"}\n\n{\"@id\": \"dtmi:test:b;1\"}\n", expectedPointers: []string{"", ""}},
+		{name: "Empty", content: "", expectedPointers: []string{}},
+		{name: "InvalidLine", content: "{\"@id\": \"dtmi:test:a;1\"}\n{\"@id\" \"dtmi:test:b;1\"}\n", expectedPointers: []string{""}, expectedOffset: 33},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			documents, err := decodeModelDocuments([]byte(tt.content))
+
+			if tt.expectedOffset > 0 {
+				if offset, ok := errorOffset(err); !ok || offset != tt.expectedOffset {
+					t.Errorf("Expected a syntax error at offset %d, but got %v", tt.expectedOffset, err)
+				}
+			} else if err != nil {
+				t.Fatalf("Expected models to be decoded, but got error: %s", err)
+			}
+
+			pointers := make([]string, len(documents))
+			for i, document := range documents {
+				pointers[i] = document.pointer
+				if document.index != i {
+					t.Errorf("Expected model %d to have index %d, but got %d", i, i, document.index)
+				}
+			}
+			if strings.Join(pointers, ",") != strings.Join(tt.expectedPointers, ",") {
+				t.Errorf("Expected pointers %v, but got %v", tt.expectedPointers, pointers)
+			}
+		})
+	}
+}
+
+func Test_writeModelArray(t *testing.T) {
+	d := ModelDirectory{}
+	_ = d.Set("../testdata/multi")
+	models, _ := d.getModels()
+
+	output := ModelDirectory{Path: t.TempDir()}
+	if err := writeModelArray(models, filepath.Join(output.Path, "models.json"), nil, false); err != nil {
+		t.Fatalf("Expected the models to be written, but got error: %s", err)
+	}
+
+	written, err := output.getModels()
+	if err != nil || len(written) != len(models) {
+		t.Fatalf("Expected %d models to be read back, but got %d (%v)", len(models), len(written), err)
+	}
+
+	for i := range models {
+		if !written[i].model.equals(models[i].model) || written[i].sourceIndex != i {
+			t.Errorf("Model %s was not written correctly", models[i].modelId)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package cli
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PatternList is a flag which can be given more than once, collecting each value given
+type PatternList []string
+
+// String returns the string representation of the object
+func (list *PatternList) String() string {
+	return strings.Join(*list, ", ")
+}
+
+// Set adds a pattern to the list
+func (list *PatternList) Set(value string) error {
+	if len(value) == 0 {
+		return fmt.Errorf("a pattern must be specified")
+	}
+	*list = append(*list, value)
+	return nil
+}
+
+// ModelFilter selects the models an operation applies to. Patterns starting with "dtmi:" are matched against model
+// ids (e.g. "dtmi:com:acme:*;*"), and any other pattern is matched against the end of the path of the file a model was
+// read from (e.g. "acme/*.json"), which only applies to models read from local storage
+type ModelFilter struct {
+	Include []string // Patterns a model must match at least one of to be selected, where no patterns selects every model
+	Exclude []string // Patterns which remove any model matching them from the selection
+}
+
+// Checks if the filter selects every model
+func (filter ModelFilter) isEmpty() bool {
+	return len(filter.Include) == 0 && len(filter.Exclude) == 0
+}
+
+// Checks that each of the patterns is a valid glob
+func (filter ModelFilter) validate() error {
+	for _, pattern := range append(append([]string{}, filter.Include...), filter.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("'%s' is not a valid pattern: %s", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Checks if the filter selects the model
+func (filter ModelFilter) matches(entry *modelEntry) bool {
+	included := len(filter.Include) == 0
+	for _, pattern := range filter.Include {
+		if matchModelPattern(pattern, entry) {
+			included = true
+			break
+		}
+	}
+
+	if !included {
+		return false
+	}
+
+	for _, pattern := range filter.Exclude {
+		if matchModelPattern(pattern, entry) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the models selected by the filter, keeping the order of the models. An error is returned if the filter is
+// not valid or does not select any models
+func (filter ModelFilter) apply(models []*modelEntry) ([]*modelEntry, error) {
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+
+	selected := make([]*modelEntry, 0, len(models))
+	for _, entry := range models {
+		if filter.matches(entry) {
+			selected = append(selected, entry)
+		}
+	}
+
+	if len(selected) == 0 && len(models) > 0 {
+		return nil, fmt.Errorf("none of the %d model(s) match the include and exclude patterns", len(models))
+	}
+	return selected, nil
+}
+
+// Checks if a model matches a pattern, either by its model id or by the path of the file it was read from. A path
+// pattern matches if it matches the last segments of the path, so "*.json" matches every JSON file
+func matchModelPattern(pattern string, entry *modelEntry) bool {
+	if strings.HasPrefix(pattern, "dtmi:") {
+		matched, _ := matchModelId(pattern, entry.modelId)
+		return matched
+	}
+
+	if len(entry.sourcePath) == 0 {
+		return false
+	}
+
+	segments := strings.Split(filepath.ToSlash(entry.sourcePath), "/")
+	patternSegments := strings.Count(strings.Trim(pattern, "/"), "/") + 1
+	if patternSegments > len(segments) {
+		return false
+	}
+
+	matched, _ := path.Match(strings.Trim(pattern, "/"), strings.Join(segments[len(segments)-patternSegments:], "/"))
+	return matched
+}
+
+// Adds the models which the selected models depend on, or target with a relationship, from the local models, so that
+// the selection can be uploaded. References to models which are not in the local models must already exist in the
+// instance, otherwise an error is returned listing them. The models are returned in the order of the local models,
+// along with the models which were added
+func includeDependencies(local []*modelEntry, selected []*modelEntry, remote []*modelEntry) ([]*modelEntry, []*modelEntry, error) {
+	localDefinitions := modelDefinitions(local)
+	remoteDefinitions := modelDefinitions(remote)
+
+	found := make(map[*modelEntry]bool)
+	for _, entry := range selected {
+		found[entry] = true
+	}
+
+	missing := make(map[string][]string)
+	queue := append([]*modelEntry{}, selected...)
+	for len(queue) > 0 {
+		entry := queue[0]
+		queue = queue[1:]
+
+		for _, reference := range entry.getModelDependencies() {
+			if dependency, ok := localDefinitions[reference.modelId]; ok {
+				if !found[dependency] {
+					found[dependency] = true
+					queue = append(queue, dependency)
+				}
+			} else if _, ok := remoteDefinitions[reference.modelId]; !ok {
+				missing[entry.modelId] = appendDistinctId(missing[entry.modelId], reference.modelId)
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		ids := make([]string, 0, len(missing))
+		for id := range missing {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		var builder strings.Builder
+		_, _ = fmt.Fprintf(&builder, "%d selected model(s) reference models which are neither in the source nor the instance:", len(ids))
+		for _, id := range ids {
+			_, _ = fmt.Fprintf(&builder, "\n  %s -> %s", id, strings.Join(missing[id], ", "))
+		}
+		return nil, nil, fmt.Errorf("%s", builder.String())
+	}
+
+	isSelected := make(map[*modelEntry]bool)
+	for _, entry := range selected {
+		isSelected[entry] = true
+	}
+
+	results := make([]*modelEntry, 0, len(found))
+	added := make([]*modelEntry, 0)
+	for _, entry := range local {
+		if found[entry] {
+			results = append(results, entry)
+			if !isSelected[entry] {
+				added = append(added, entry)
+			}
+		}
+	}
+
+	return results, added, nil
+}
+
+// Appends the id to the collection if it is not already present
+func appendDistinctId(ids []string, id string) []string {
+	for _, existing := range ids {
+		if existing == id {
+			return ids
+		}
+	}
+	return append(ids, id)
+}
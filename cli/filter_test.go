@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+// Creates a test model which was read from the given file
+func newTestFileEntry(t *testing.T, content string, sourcePath string) *modelEntry {
+	entry := newTestEntry(t, content)
+	entry.sourcePath = sourcePath
+	entry.sourceIndex = -1
+	return entry
+}
+
+// Gets the ids of the models, separated by commas
+func joinModelIds(models []*modelEntry) string {
+	ids := make([]string, len(models))
+	for i, entry := range models {
+		ids[i] = entry.modelId
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestModelFilter_apply(t *testing.T) {
+	models := []*modelEntry{
+		newTestFileEntry(t, `{"@id": "dtmi:com:acme:building;1", "@type": "Interface"}`, "models/acme/building.json"),
+		newTestFileEntry(t, `{"@id": "dtmi:com:acme:room;2", "@type": "Interface"}`, "models/acme/spaces/room.json"),
+		newTestFileEntry(t, `{"@id": "dtmi:com:other:room;1", "@type": "Interface"}`, "models/other/room.dtdl"),
+		newTestEntry(t, `{"@id": "dtmi:com:other:level;1", "@type": "Interface"}`),
+	}
+
+	tests := []struct {
+		name          string
+		filter        ModelFilter
+		expected      string
+		expectedError string
+	}{
+		{"No patterns", ModelFilter{}, "dtmi:com:acme:building;1,dtmi:com:acme:room;2,dtmi:com:other:room;1,dtmi:com:other:level;1", ""},
+		{"Model id", ModelFilter{Include: []string{"dtmi:com:acme:*;*"}}, "dtmi:com:acme:building;1,dtmi:com:acme:room;2", ""},
+		{"File name", ModelFilter{Include: []string{"room.*"}}, "dtmi:com:acme:room;2,dtmi:com:other:room;1", ""},
+		{"File path", ModelFilter{Include: []string{"acme/*.json"}}, "dtmi:com:acme:building;1", ""},
+		{"Exclude", ModelFilter{Include: []string{"dtmi:com:*"}, Exclude: []string{"dtmi:com:*:room;*", "acme/building.json"}}, "dtmi:com:other:level;1", ""},
+		{"Several includes", ModelFilter{Include: []string{"dtmi:com:other:level;1", "other/*"}}, "dtmi:com:other:room;1,dtmi:com:other:level;1", ""},
+		{"No match", ModelFilter{Include: []string{"dtmi:com:missing:*"}}, "", "none of the 4 model(s) match"},
+		{"Invalid pattern", ModelFilter{Exclude: []string{"dtmi:com:[acme"}}, "", "is not a valid pattern"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := tt.filter.apply(models)
+
+			if len(tt.expectedError) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Errorf("Expected error containing '%s', but got: %v", tt.expectedError, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Expected models to be selected, but got error: %s", err)
+			}
+
+			if ids := joinModelIds(selected); ids != tt.expected {
+				t.Errorf("Expected %s but got %s", tt.expected, ids)
+			}
+		})
+	}
+}
+
+func Test_includeDependencies(t *testing.T) {
+	space := newTestEntry(t, `{"@id": "dtmi:com:acme:space;1", "@type": "Interface"}`)
+	room := newTestEntry(t, `{"@id": "dtmi:com:acme:room;1", "@type": "Interface", "extends": "dtmi:com:acme:space;1"}`)
+	level := newTestEntry(t, `{"@id": "dtmi:com:acme:level;1", "@type": "Interface", "extends": "dtmi:com:shared:area;1",
+		"contents": [{"@type": "Relationship", "name": "hasRooms", "target": "dtmi:com:acme:room;1"}]}`)
+	unrelated := newTestEntry(t, `{"@id": "dtmi:com:other:floor;1", "@type": "Interface"}`)
+	local := []*modelEntry{space, room, level, unrelated}
+
+	remote := []*modelEntry{newTestEntry(t, `{"@id": "dtmi:com:shared:area;1", "@type": "Interface"}`)}
+
+	models, added, err := includeDependencies(local, []*modelEntry{level}, remote)
+	if err != nil {
+		t.Fatalf("Expected the dependencies to be included, but got error: %s", err)
+	}
+
+	if ids := joinModelIds(models); ids != "dtmi:com:acme:space;1,dtmi:com:acme:room;1,dtmi:com:acme:level;1" {
+		t.Errorf("Unexpected models selected: %s", ids)
+	}
+	if ids := joinModelIds(added); ids != "dtmi:com:acme:space;1,dtmi:com:acme:room;1" {
+		t.Errorf("Unexpected models added: %s", ids)
+	}
+
+	_, _, err = includeDependencies(local, []*modelEntry{level}, nil)
+	if err == nil || !strings.Contains(err.Error(), "dtmi:com:acme:level;1 -> dtmi:com:shared:area;1") {
+		t.Errorf("Expected an error listing the missing reference, but got: %v", err)
+	}
+}
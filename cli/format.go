@@ -0,0 +1,224 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Line endings which can be used when formatting models
+const (
+	LineEndingsLF   = "lf"   // Unix line endings
+	LineEndingsCRLF = "crlf" // Windows line endings
+)
+
+// ErrNotFormatted is returned by FormatModels in check mode when any of the files are not formatted
+var ErrNotFormatted = errors.New("model files are not formatted")
+
+// The keys which are written first, in this order, when formatting an object. Any other keys follow in alphabetical
+// order
+var canonicalKeyOrder = []string{"@context", "@id", "@type", "displayName", "description", "extends", "contents", "schemas"}
+
+// FormatOptions controls how models are written in the canonical format
+type FormatOptions struct {
+	Indent      string // The indentation for each level, either a number of spaces or "tab"
+	LineEndings string // The line endings to use, either LineEndingsLF or LineEndingsCRLF
+	Check       bool   // When set, files which are not formatted are listed instead of being written
+}
+
+// modelFormatter writes models as JSON with their keys in the canonical DTDL order, using a fixed indentation and line
+// ending
+type modelFormatter struct {
+	indent  string // The indentation for each level
+	newline string // The line ending
+}
+
+// Creates a formatter using the indentation and line endings of the options
+func newModelFormatter(options FormatOptions) (*modelFormatter, error) {
+	formatter := modelFormatter{}
+
+	switch strings.ToLower(options.Indent) {
+	case "", "2":
+		formatter.indent = "  "
+	case "tab":
+		formatter.indent = "\t"
+	default:
+		spaces, err := strconv.Atoi(options.Indent)
+		if err != nil || spaces < 0 || spaces > 8 {
+			return nil, fmt.Errorf("indent '%s' is not valid, it should be a number of spaces from 0 to 8 or 'tab'", options.Indent)
+		}
+		formatter.indent = strings.Repeat(" ", spaces)
+	}
+
+	switch strings.ToLower(options.LineEndings) {
+	case "", LineEndingsLF:
+		formatter.newline = "\n"
+	case LineEndingsCRLF:
+		formatter.newline = "\r\n"
+	default:
+		return nil, fmt.Errorf("line endings '%s' are not valid, only '%s' or '%s' should be provided", options.LineEndings, LineEndingsLF, LineEndingsCRLF)
+	}
+
+	return &formatter, nil
+}
+
+// Formats a JSON value, ending the content with a line ending
+func (formatter *modelFormatter) format(value interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := formatter.write(&buffer, value, 0, formatter.indent); err != nil {
+		return nil, err
+	}
+	buffer.WriteString(formatter.newline)
+	return buffer.Bytes(), nil
+}
+
+// Formats each of the values on its own line without any indentation, as used by newline delimited JSON
+func (formatter *modelFormatter) formatLines(values []interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	for _, value := range values {
+		if err := formatter.write(&buffer, value, 0, ""); err != nil {
+			return nil, err
+		}
+		buffer.WriteString(formatter.newline)
+	}
+	return buffer.Bytes(), nil
+}
+
+// Writes a value at the given depth. An empty indent writes the value on a single line
+func (formatter *modelFormatter) write(buffer *bytes.Buffer, value interface{}, depth int, indent string) error {
+	newline := func(depth int) {
+		if len(indent) > 0 {
+			buffer.WriteString(formatter.newline)
+			buffer.WriteString(strings.Repeat(indent, depth))
+		}
+	}
+	separator := ":"
+	if len(indent) > 0 {
+		separator = ": "
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if len(value) == 0 {
+			buffer.WriteString("{}")
+			return nil
+		}
+
+		buffer.WriteString("{")
+		for i, key := range canonicalKeys(value) {
+			if i > 0 {
+				buffer.WriteString(",")
+			}
+			newline(depth + 1)
+			if err := writeJsonScalar(buffer, key); err != nil {
+				return err
+			}
+			buffer.WriteString(separator)
+			if err := formatter.write(buffer, value[key], depth+1, indent); err != nil {
+				return err
+			}
+		}
+		newline(depth)
+		buffer.WriteString("}")
+	case jsonObject:
+		return formatter.write(buffer, map[string]interface{}(value), depth, indent)
+	case []interface{}:
+		if len(value) == 0 {
+			buffer.WriteString("[]")
+			return nil
+		}
+
+		buffer.WriteString("[")
+		for i, item := range value {
+			if i > 0 {
+				buffer.WriteString(",")
+			}
+			newline(depth + 1)
+			if err := formatter.write(buffer, item, depth+1, indent); err != nil {
+				return err
+			}
+		}
+		newline(depth)
+		buffer.WriteString("]")
+	default:
+		return writeJsonScalar(buffer, value)
+	}
+
+	return nil
+}
+
+// Writes a string, number, boolean or null without escaping HTML characters, so that text in the model is unchanged
+func writeJsonScalar(buffer *bytes.Buffer, value interface{}) error {
+	var scalar bytes.Buffer
+	encoder := json.NewEncoder(&scalar)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return err
+	}
+	buffer.Write(bytes.TrimSuffix(scalar.Bytes(), []byte("\n")))
+	return nil
+}
+
+// Gets the keys of an object in the canonical DTDL order
+func canonicalKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for _, key := range canonicalKeyOrder {
+		if _, ok := object[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+
+	rest := make([]string, 0, len(object)-len(keys))
+	for key := range object {
+		if !isCanonicalKey(key) {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+
+	return append(keys, rest...)
+}
+
+// Checks if the key is one of those with a fixed position in the canonical order
+func isCanonicalKey(key string) bool {
+	for _, canonical := range canonicalKeyOrder {
+		if key == canonical {
+			return true
+		}
+	}
+	return false
+}
+
+// Formats the content of a model file, keeping its structure. A file holding a single model or an array of models is
+// indented, and a file holding several values (newline delimited JSON) is written with one value per line. Numbers are
+// written exactly as they appear in the file
+func (formatter *modelFormatter) formatFile(content []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	values := make([]interface{}, 0, 1)
+	for {
+		var value interface{}
+		err := decoder.Decode(&value)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("file does not contain valid JSON: %s", err)
+		}
+		values = append(values, value)
+	}
+
+	switch len(values) {
+	case 0:
+		return nil, fmt.Errorf("file does not contain any models")
+	case 1:
+		return formatter.format(values[0])
+	default:
+		return formatter.formatLines(values)
+	}
+}
@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_modelFormatter_formatFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  FormatOptions
+		content  string
+		expected string
+	}{
+		{
+			"Canonical order",
+			FormatOptions{},
+			`{"contents": [], "zeta": 1, "@type": "Interface", "displayName": "Room", "@id": "dtmi:com:example:room;1", "alpha": true, "@context": "dtmi:dtdl:context;2"}`,
+			"{\n  \"@context\": \"dtmi:dtdl:context;2\",\n  \"@id\": \"dtmi:com:example:room;1\",\n  \"@type\": \"Interface\",\n  \"displayName\": \"Room\",\n  \"contents\": [],\n  \"alpha\": true,\n  \"zeta\": 1\n}\n",
+		},
+		{
+			"Nested objects",
+			FormatOptions{Indent: "tab"},
+			`{"@id": "dtmi:com:example:room;1", "contents": [{"schema": "double", "name": "temp", "@type": "Property"}]}`,
+			"{\n\t\"@id\": \"dtmi:com:example:room;1\",\n\t\"contents\": [\n\t\t{\n\t\t\t\"@type\": \"Property\",\n\t\t\t\"name\": \"temp\",\n\t\t\t\"schema\": \"double\"\n\t\t}\n\t]\n}\n",
+		},
+		{
+			"Numbers and text are unchanged",
+			FormatOptions{Indent: "4", LineEndings: LineEndingsCRLF},
+			`{"maxMultiplicity": 1.50, "description": "<b>Room</b> & more"}`,
+			"{\r\n    \"description\": \"<b>Room</b> & more\",\r\n    \"maxMultiplicity\": 1.50\r\n}\r\n",
+		},
+		{
+			"Newline delimited JSON",
+			FormatOptions{},
+			"{\"@type\": \"Interface\", \"@id\": \"dtmi:com:example:room;1\"}\n{\"@type\": \"Interface\", \"@id\": \"dtmi:com:example:level;1\"}",
+			"{\"@id\":\"dtmi:com:example:room;1\",\"@type\":\"Interface\"}\n{\"@id\":\"dtmi:com:example:level;1\",\"@type\":\"Interface\"}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			formatter, err := newModelFormatter(tt.options)
+			if err != nil {
+				t.Fatalf("Expected a formatter, but got error: %s", err)
+			}
+
+			actual, err := formatter.formatFile([]byte(tt.content))
+			if err != nil {
+				t.Fatalf("Expected the file to be formatted, but got error: %s", err)
+			}
+
+			if string(actual) != tt.expected {
+				t.Errorf("Expected:\n%q\nbut got:\n%q", tt.expected, string(actual))
+			}
+
+			again, _ := formatter.formatFile(actual)
+			if string(again) != string(actual) {
+				t.Errorf("Expected formatting to be unchanged when applied twice, but got:\n%q", string(again))
+			}
+		})
+	}
+}
+
+func Test_newModelFormatter_invalid(t *testing.T) {
+	tests := []struct {
+		options       FormatOptions
+		expectedError string
+	}{
+		{FormatOptions{Indent: "9"}, "indent '9' is not valid"},
+		{FormatOptions{Indent: "spaces"}, "indent 'spaces' is not valid"},
+		{FormatOptions{LineEndings: "cr"}, "line endings 'cr' are not valid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expectedError, func(t *testing.T) {
+			_, err := newModelFormatter(tt.options)
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("Expected error containing '%s', but got: %v", tt.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestFormatModels(t *testing.T) {
+	directory := t.TempDir()
+	formattedPath := filepath.Join(directory, "level.json")
+	unformattedPath := filepath.Join(directory, "room.json")
+	_ = os.WriteFile(formattedPath, []byte("{\n  \"@id\": \"dtmi:com:example:level;1\",\n  \"@type\": \"Interface\"\n}\n"), os.ModePerm)
+	_ = os.WriteFile(unformattedPath, []byte(`{"@type": "Interface", "@id": "dtmi:com:example:room;1"}`), os.ModePerm)
+
+	source := ModelDirectory{}
+	_ = source.Set(directory)
+
+	err := FormatModels(source, FormatOptions{Check: true})
+	if !errors.Is(err, ErrNotFormatted) {
+		t.Fatalf("Expected the check to fail as a file is not formatted, but got: %v", err)
+	}
+
+	if err := FormatModels(source, FormatOptions{}); err != nil {
+		t.Fatalf("Expected the files to be formatted, but got error: %s", err)
+	}
+
+	content, _ := os.ReadFile(unformattedPath)
+	if string(content) != "{\n  \"@id\": \"dtmi:com:example:room;1\",\n  \"@type\": \"Interface\"\n}\n" {
+		t.Errorf("Unexpected formatted content: %q", string(content))
+	}
+
+	if err := FormatModels(source, FormatOptions{Check: true}); err != nil {
+		t.Errorf("Expected the check to pass once formatted, but got: %v", err)
+	}
+}
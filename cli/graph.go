@@ -0,0 +1,209 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Formats the model graph can be written in
+const (
+	GraphFormatDot     = "dot"     // Graphviz DOT
+	GraphFormatMermaid = "mermaid" // Mermaid flowchart
+	GraphFormatJson    = "json"    // JSON adjacency list
+)
+
+// graphEdge is a reference from one model to another in the model graph
+type graphEdge struct {
+	from *modelEntry   // The model holding the reference
+	to   *modelEntry   // The model being referenced
+	kind referenceKind // How the model is referenced
+}
+
+// modelGraph holds the models and the references between them
+type modelGraph struct {
+	nodes []*modelEntry // The models in the graph, in the order they were read
+	edges []graphEdge   // The references between the models
+}
+
+// Builds the graph of references between the models. References to elements defined inside a model are shown as
+// references to the model defining them, and references to models which are not in the collection are left out
+func buildModelGraph(models []*modelEntry) *modelGraph {
+	definitions := modelDefinitions(models)
+	graph := modelGraph{nodes: models, edges: make([]graphEdge, 0)}
+
+	for _, entry := range models {
+		seen := make(map[string]bool)
+		for _, reference := range entry.getModelDependencies() {
+			target, ok := definitions[reference.modelId]
+			if !ok || target == entry {
+				continue
+			}
+
+			key := target.modelId + "|" + reference.kind.String()
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			graph.edges = append(graph.edges, graphEdge{from: entry, to: target, kind: reference.kind})
+		}
+	}
+
+	return &graph
+}
+
+// Reverses the direction of every edge, so that each model points to the models which reference it
+func (graph *modelGraph) reverse() *modelGraph {
+	reversed := modelGraph{nodes: graph.nodes, edges: make([]graphEdge, len(graph.edges))}
+	for i, edge := range graph.edges {
+		reversed.edges[i] = graphEdge{from: edge.to, to: edge.from, kind: edge.kind}
+	}
+	return &reversed
+}
+
+// Gets the part of the graph which can be reached by following edges from the root model, up to the given depth. A
+// depth of zero or less does not limit how far the edges are followed
+func (graph *modelGraph) subgraph(rootId string, depth int) (*modelGraph, error) {
+	var root *modelEntry
+	for _, entry := range graph.nodes {
+		if entry.modelId == rootId {
+			root = entry
+			break
+		}
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("the model %s was not found", rootId)
+	}
+
+	outgoing := make(map[*modelEntry][]graphEdge)
+	for _, edge := range graph.edges {
+		outgoing[edge.from] = append(outgoing[edge.from], edge)
+	}
+
+	distance := map[*modelEntry]int{root: 0}
+	included := make(map[graphEdge]bool)
+	queue := []*modelEntry{root}
+
+	for len(queue) > 0 {
+		entry := queue[0]
+		queue = queue[1:]
+
+		if depth > 0 && distance[entry] >= depth {
+			continue
+		}
+
+		for _, edge := range outgoing[entry] {
+			included[edge] = true
+			if _, visited := distance[edge.to]; !visited {
+				distance[edge.to] = distance[entry] + 1
+				queue = append(queue, edge.to)
+			}
+		}
+	}
+
+	result := modelGraph{nodes: make([]*modelEntry, 0, len(distance)), edges: make([]graphEdge, 0, len(included))}
+	for _, entry := range graph.nodes {
+		if _, ok := distance[entry]; ok {
+			result.nodes = append(result.nodes, entry)
+		}
+	}
+	for _, edge := range graph.edges {
+		if included[edge] {
+			result.edges = append(result.edges, edge)
+		}
+	}
+
+	return &result, nil
+}
+
+// Writes the graph in the given format
+func (graph *modelGraph) write(out io.Writer, format string) error {
+	switch format {
+	case GraphFormatDot:
+		return graph.writeDot(out)
+	case GraphFormatMermaid:
+		return graph.writeMermaid(out)
+	case GraphFormatJson:
+		return graph.writeJson(out)
+	default:
+		return fmt.Errorf("graph format '%s' is not valid, only '%s', '%s' or '%s' should be provided", format, GraphFormatDot, GraphFormatMermaid, GraphFormatJson)
+	}
+}
+
+// Writes the graph as a Graphviz DOT digraph
+func (graph *modelGraph) writeDot(out io.Writer) error {
+	quote := func(value string) string {
+		return `"` + strings.ReplaceAll(strings.ReplaceAll(value, `\`, `\\`), `"`, `\"`) + `"`
+	}
+
+	var builder strings.Builder
+	builder.WriteString("digraph models {\n")
+	builder.WriteString("  rankdir=LR;\n")
+	builder.WriteString("  node [shape=box];\n")
+	for _, entry := range graph.nodes {
+		_, _ = fmt.Fprintf(&builder, "  %s;\n", quote(entry.modelId))
+	}
+	for _, edge := range graph.edges {
+		_, _ = fmt.Fprintf(&builder, "  %s -> %s [label=%s];\n", quote(edge.from.modelId), quote(edge.to.modelId), quote(edge.kind.String()))
+	}
+	builder.WriteString("}\n")
+
+	_, err := io.WriteString(out, builder.String())
+	return err
+}
+
+// Writes the graph as a Mermaid flowchart. Model ids contain characters which are not allowed in Mermaid node ids, and
+// so each model is given a generated node id and labelled with its model id
+func (graph *modelGraph) writeMermaid(out io.Writer) error {
+	nodeIds := make(map[*modelEntry]string)
+
+	var builder strings.Builder
+	builder.WriteString("flowchart LR\n")
+	for i, entry := range graph.nodes {
+		nodeIds[entry] = fmt.Sprintf("n%d", i)
+		_, _ = fmt.Fprintf(&builder, "  %s[\"%s\"]\n", nodeIds[entry], strings.ReplaceAll(entry.modelId, `"`, "#quot;"))
+	}
+	for _, edge := range graph.edges {
+		_, _ = fmt.Fprintf(&builder, "  %s -->|%s| %s\n", nodeIds[edge.from], edge.kind, nodeIds[edge.to])
+	}
+
+	_, err := io.WriteString(out, builder.String())
+	return err
+}
+
+// graphNodeJson defines a model, and the models it references, in the JSON adjacency list
+type graphNodeJson struct {
+	Id         string          `json:"id"`         // The id of the model
+	References []graphEdgeJson `json:"references"` // The models referenced by the model
+}
+
+// graphEdgeJson defines a reference to a model in the JSON adjacency list
+type graphEdgeJson struct {
+	Id   string `json:"id"`   // The id of the model being referenced
+	Kind string `json:"kind"` // How the model is referenced
+}
+
+// Writes the graph as a JSON adjacency list
+func (graph *modelGraph) writeJson(out io.Writer) error {
+	nodes := make([]graphNodeJson, len(graph.nodes))
+	index := make(map[*modelEntry]int)
+	for i, entry := range graph.nodes {
+		nodes[i] = graphNodeJson{Id: entry.modelId, References: make([]graphEdgeJson, 0)}
+		index[entry] = i
+	}
+	for _, edge := range graph.edges {
+		node := &nodes[index[edge.from]]
+		node.References = append(node.References, graphEdgeJson{Id: edge.to.modelId, Kind: edge.kind.String()})
+	}
+
+	content, err := json.MarshalIndent(nodes, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(out, string(content))
+	return err
+}
@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// Creates the graph of the test models
+func newTestGraph(t *testing.T) *modelGraph {
+	d := ModelDirectory{}
+	_ = d.Set("../testdata/models")
+	models, err := d.getModels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buildModelGraph(models)
+}
+
+// Gets each edge of the graph as "from -kind-> to"
+func edgeStrings(graph *modelGraph) []string {
+	edges := make([]string, len(graph.edges))
+	for i, edge := range graph.edges {
+		edges[i] = edge.from.modelId + " -" + edge.kind.String() + "-> " + edge.to.modelId
+	}
+	return edges
+}
+
+func Test_buildModelGraph(t *testing.T) {
+	graph := newTestGraph(t)
+
+	if len(graph.nodes) != 5 {
+		t.Errorf("Expected 5 nodes, but got %d", len(graph.nodes))
+	}
+
+	edges := strings.Join(edgeStrings(graph), "\n")
+	for _, expected := range []string{
+		"dtmi:digitaltwins:testing:core:room;1 -extends-> dtmi:digitaltwins:testing:core:space;1",
+		"dtmi:digitaltwins:testing:core:level;1 -relationship-> dtmi:digitaltwins:testing:core:room;1",
+	} {
+		if !strings.Contains(edges, expected) {
+			t.Errorf("Expected the edge '%s' in the graph, but got:\n%s", expected, edges)
+		}
+	}
+}
+
+func Test_modelGraph_subgraph(t *testing.T) {
+	graph := newTestGraph(t)
+
+	tests := []struct {
+		name          string
+		graph         *modelGraph
+		root          string
+		depth         int
+		expectedNodes int
+		expectedEdges int
+	}{
+		{"All dependencies", graph, "dtmi:digitaltwins:testing:core:level;1", 0, 3, 3},
+		{"Limited depth", graph, "dtmi:digitaltwins:testing:core:level;1", 1, 3, 2},
+		{"Reverse dependencies", graph.reverse(), "dtmi:digitaltwins:testing:core:room;1", 0, 4, 3},
+		{"Leaf model", graph, "dtmi:digitaltwins:testing:core:space;1", 0, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subgraph, err := tt.graph.subgraph(tt.root, tt.depth)
+			if err != nil {
+				t.Fatalf("Expected a subgraph, but got error: %s", err)
+			}
+
+			if len(subgraph.nodes) != tt.expectedNodes || len(subgraph.edges) != tt.expectedEdges {
+				t.Errorf("Expected %d nodes and %d edges, but got %d nodes and %d edges:\n%s",
+					tt.expectedNodes, tt.expectedEdges, len(subgraph.nodes), len(subgraph.edges), strings.Join(edgeStrings(subgraph), "\n"))
+			}
+		})
+	}
+
+	if _, err := graph.subgraph("dtmi:digitaltwins:testing:core:missing;1", 0); err == nil {
+		t.Errorf("Expected an error for a root which is not in the graph")
+	}
+}
+
+func Test_modelGraph_write(t *testing.T) {
+	graph, _ := newTestGraph(t).subgraph("dtmi:digitaltwins:testing:core:room;1", 0)
+
+	var out bytes.Buffer
+	if err := graph.write(&out, GraphFormatDot); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"dtmi:digitaltwins:testing:core:room;1" -> "dtmi:digitaltwins:testing:core:space;1" [label="extends"];`) {
+		t.Errorf("Unexpected DOT output:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := graph.write(&out, GraphFormatMermaid); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "n0 -->|extends| n1") {
+		t.Errorf("Unexpected Mermaid output:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := graph.write(&out, GraphFormatJson); err != nil {
+		t.Fatal(err)
+	}
+	var nodes []graphNodeJson
+	if err := json.Unmarshal(out.Bytes(), &nodes); err != nil {
+		t.Fatalf("Expected valid JSON, but got error: %s", err)
+	}
+	if len(nodes) != 2 || len(nodes[0].References) != 1 || nodes[0].References[0].Kind != "extends" {
+		t.Errorf("Unexpected JSON output:\n%s", out.String())
+	}
+
+	if err := graph.write(&out, "svg"); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// errTwinQueryForbidden is returned when the credentials are not allowed to query the twins in the instance, which
+// needs a data plane role (such as Azure Digital Twins Data Reader) rather than permission to read models
+var errTwinQueryForbidden = errors.New("the credentials do not have permission to query twins")
+
+// impactedModel is a model which would be affected by a change to another model, because it references the changed
+// model either directly or through other affected models
+type impactedModel struct {
+	entry      *modelEntry // The affected model
+	references []graphEdge // The references from the model to the changed model or to other affected models
+}
+
+// Finds every model which transitively extends, embeds as a component, uses a schema from, or targets with a
+// relationship the given model. The affected models are returned in order of their model id
+func findImpactedModels(graph *modelGraph, modelId string) ([]impactedModel, error) {
+	dependents, err := graph.reverse().subgraph(modelId, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	affected := make(map[*modelEntry]bool)
+	for _, entry := range dependents.nodes {
+		affected[entry] = true
+	}
+
+	results := make([]impactedModel, 0, len(dependents.nodes))
+	for _, entry := range dependents.nodes {
+		if entry.modelId == modelId {
+			continue
+		}
+
+		impacted := impactedModel{entry: entry, references: make([]graphEdge, 0)}
+		for _, edge := range graph.edges {
+			if edge.from == entry && affected[edge.to] {
+				impacted.references = append(impacted.references, edge)
+			}
+		}
+		results = append(results, impacted)
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].entry.modelId < results[j].entry.modelId })
+
+	return results, nil
+}
+
+// Writes out the models affected by a change to the given model, with the number of twins using each model when the
+// counts are known
+func printImpact(modelId string, impacted []impactedModel, twinCounts map[string]int) {
+	if len(impacted) == 0 {
+		fmt.Printf("No models depend on %s\n", modelId)
+	} else {
+		fmt.Printf("%d model(s) depend on %s:\n", len(impacted), modelId)
+	}
+
+	for _, model := range impacted {
+		if count, ok := twinCounts[model.entry.modelId]; ok {
+			fmt.Printf("  %s (%d twins)\n", model.entry.modelId, count)
+		} else {
+			fmt.Printf("  %s\n", model.entry.modelId)
+		}
+
+		for _, edge := range model.references {
+			fmt.Printf("      %s %s\n", edge.kind, edge.to.modelId)
+		}
+	}
+
+	if twinCounts == nil {
+		return
+	}
+
+	total := 0
+	for _, count := range twinCounts {
+		total += count
+	}
+	fmt.Printf("%d twin(s) would be affected, %d of which use %s\n", total, twinCounts[modelId], modelId)
+}
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func Test_findImpactedModels(t *testing.T) {
+	graph := newTestGraph(t)
+
+	impacted, err := findImpactedModels(graph, "dtmi:digitaltwins:testing:core:room;1")
+	if err != nil {
+		t.Fatalf("Expected impacted models, but got error: %s", err)
+	}
+
+	expected := []string{
+		"dtmi:digitaltwins:testing:core:building;1 relationship dtmi:digitaltwins:testing:core:level;1",
+		"dtmi:digitaltwins:testing:core:level;1 relationship dtmi:digitaltwins:testing:core:room;1",
+		"dtmi:digitaltwins:testing:core:meetingroom;1 extends dtmi:digitaltwins:testing:core:room;1",
+	}
+
+	actual := make([]string, 0)
+	for _, model := range impacted {
+		for _, edge := range model.references {
+			actual = append(actual, model.entry.modelId+" "+edge.kind.String()+" "+edge.to.modelId)
+		}
+	}
+
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected:\n%s\nbut got:\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+
+	if _, err := findImpactedModels(graph, "dtmi:digitaltwins:testing:core:missing;1"); err == nil {
+		t.Errorf("Expected an error for a model which does not exist")
+	}
+}
+
+func Test_client_countTwins(t *testing.T) {
+	token := &azcore.AccessToken{Token: "token"}
+
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/query" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		var request map[string]string
+		_ = json.Unmarshal(body, &request)
+
+		if strings.Contains(request["query"], "'dtmi:test:forbidden;1'") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"value": [{"COUNT": 12}], "continuationToken": null}`))
+	})
+
+	count, err := client.countTwins(context.Background(), "dtmi:test:room;1", token)
+	if err != nil || count != 12 {
+		t.Errorf("Expected 12 twins, but got %d (%v)", count, err)
+	}
+
+	if _, err := client.countTwins(context.Background(), "dtmi:test:forbidden;1", token); !errors.Is(err, errTwinQueryForbidden) {
+		t.Errorf("Expected a forbidden error, but got: %v", err)
+	}
+}
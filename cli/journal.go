@@ -0,0 +1,231 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// DefaultJournalPath is the default location of the journal recording the progress of an upload
+const DefaultJournalPath = ".adt-upload.jsonl"
+
+type journalRecordType string
+
+const (
+	journalPlanRecord   journalRecordType = "plan"   // Records the batches an upload will be made in
+	journalCommitRecord journalRecordType = "commit" // Records a batch which has been uploaded successfully
+)
+
+// journalRecord is a single line of an upload journal. The first line of a journal is always a plan record, followed
+// by a commit record for each batch uploaded
+type journalRecord struct {
+	Type     journalRecordType `json:"type"`               // The type of record
+	Time     time.Time         `json:"time"`               // When the record was written
+	Endpoint string            `json:"endpoint,omitempty"` // For plan records, the instance the models are being uploaded to
+	Source   string            `json:"source,omitempty"`   // For plan records, the location the models are being uploaded from
+	Batches  [][]string        `json:"batches,omitempty"`  // For plan records, the ids of the models in each batch
+	Batch    int               `json:"batch"`              // For commit records, the index of the batch which was uploaded
+	ModelIds []string          `json:"modelIds,omitempty"` // For commit records, the ids of the models which were uploaded
+}
+
+// uploadJournal records the progress of an upload in a file, so that an upload which fails can be resumed from the
+// first batch which was not uploaded
+type uploadJournal struct {
+	path      string       // The location of the journal file
+	endpoint  string       // The instance the models are being uploaded to
+	source    string       // The location the models are being uploaded from
+	batches   [][]string   // The ids of the models in each batch, in the order they are uploaded
+	committed map[int]bool // The indexes of the batches which have been uploaded
+	file      *os.File     // The open journal file, when records are being written
+}
+
+// Creates a new journal for an upload of the batches, replacing any existing journal at the path
+func createJournal(path string, endpoint string, source string, batches [][]*modelEntry) (*uploadJournal, error) {
+	journal := uploadJournal{
+		path:      path,
+		endpoint:  endpoint,
+		source:    source,
+		batches:   make([][]string, len(batches)),
+		committed: make(map[int]bool),
+	}
+
+	for i, batch := range batches {
+		journal.batches[i] = make([]string, len(batch))
+		for j, entry := range batch {
+			journal.batches[i][j] = entry.modelId
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create journal %s: %s", path, err)
+	}
+	journal.file = file
+
+	err = journal.write(journalRecord{Type: journalPlanRecord, Endpoint: endpoint, Source: source, Batches: journal.batches})
+	if err != nil {
+		_ = journal.close()
+		return nil, err
+	}
+
+	return &journal, nil
+}
+
+// Reads an existing journal. A final line which is incomplete, as happens if the process is stopped while it is being
+// written, is ignored
+func readJournal(path string) (*uploadJournal, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := bytes.Split(bytes.TrimRight(content, "\n"), []byte("\n"))
+	journal := uploadJournal{path: path, committed: make(map[int]bool)}
+
+	for i, line := range lines {
+		var record journalRecord
+		if err = json.Unmarshal(line, &record); err != nil {
+			if i == len(lines)-1 && i > 0 {
+				break
+			}
+			return nil, fmt.Errorf("line %d of journal %s is not valid: %s", i+1, path, err)
+		}
+
+		if i == 0 {
+			if record.Type != journalPlanRecord {
+				return nil, fmt.Errorf("journal %s does not start with a plan record", path)
+			}
+			journal.endpoint = record.Endpoint
+			journal.source = record.Source
+			journal.batches = record.Batches
+			continue
+		}
+
+		if record.Type != journalCommitRecord || record.Batch < 0 || record.Batch >= len(journal.batches) {
+			return nil, fmt.Errorf("line %d of journal %s is not a valid commit record", i+1, path)
+		}
+		journal.committed[record.Batch] = true
+	}
+
+	return &journal, nil
+}
+
+// Checks if a journal exists at the path
+func journalExists(path string) bool {
+	_, err := os.Stat(path)
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+// Opens the journal so that further records can be added to it
+func (journal *uploadJournal) open() error {
+	file, err := os.OpenFile(journal.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open journal %s: %s", journal.path, err)
+	}
+	journal.file = file
+	return nil
+}
+
+// Records that a batch has been uploaded successfully
+func (journal *uploadJournal) commit(batch int) error {
+	journal.committed[batch] = true
+	return journal.write(journalRecord{Type: journalCommitRecord, Batch: batch, ModelIds: journal.batches[batch]})
+}
+
+// Gets the index of the first batch which has not been uploaded, or the number of batches if all have been uploaded
+func (journal *uploadJournal) firstUncommitted() int {
+	for i := range journal.batches {
+		if !journal.committed[i] {
+			return i
+		}
+	}
+	return len(journal.batches)
+}
+
+// Writes a record to the end of the journal, making sure it has been written to disk before returning
+func (journal *uploadJournal) write(record journalRecord) error {
+	record.Time = time.Now().UTC()
+	content, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(journal.file)
+	_, _ = writer.Write(content)
+	_ = writer.WriteByte('\n')
+	if err = writer.Flush(); err == nil {
+		err = journal.file.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write to journal %s: %s", journal.path, err)
+	}
+
+	return nil
+}
+
+// Closes the journal file
+func (journal *uploadJournal) close() error {
+	if journal.file == nil {
+		return nil
+	}
+	err := journal.file.Close()
+	journal.file = nil
+	return err
+}
+
+// Closes and removes the journal, once the upload it records has completed
+func (journal *uploadJournal) remove() error {
+	_ = journal.close()
+	return os.Remove(journal.path)
+}
+
+// Checks the journal against the instance and gets the models for each batch from the local models. Batches recorded
+// as uploaded must exist in the instance. Batches which were not recorded but which exist in the instance, as happens
+// if the process stopped before the commit record was written, are treated as uploaded
+func (journal *uploadJournal) resolve(local []*modelEntry, remote []*modelEntry) ([][]*modelEntry, error) {
+	localModels := make(map[string]*modelEntry)
+	for _, entry := range local {
+		localModels[entry.modelId] = entry
+	}
+
+	remoteIds := make(map[string]bool)
+	for _, entry := range remote {
+		remoteIds[entry.modelId] = true
+	}
+
+	batches := make([][]*modelEntry, len(journal.batches))
+	for i, ids := range journal.batches {
+		existing := 0
+		for _, id := range ids {
+			if remoteIds[id] {
+				existing++
+			} else if journal.committed[i] {
+				return nil, fmt.Errorf("model %s in batch %d was recorded as uploaded but does not exist in the instance", id, i+1)
+			}
+		}
+
+		if existing == len(ids) {
+			journal.committed[i] = true
+			continue
+		} else if existing > 0 {
+			return nil, fmt.Errorf("batch %d has only been partially uploaded to the instance, it may have been changed by another process", i+1)
+		}
+
+		batch := make([]*modelEntry, 0, len(ids))
+		for _, id := range ids {
+			entry, ok := localModels[id]
+			if !ok {
+				return nil, fmt.Errorf("model %s in batch %d was not found in the source models", id, i+1)
+			}
+			batch = append(batch, entry)
+		}
+		batches[i] = batch
+	}
+
+	return batches, nil
+}
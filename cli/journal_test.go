@@ -0,0 +1,181 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Writes each model to its own file in a new directory, returning the directory as a model source
+func newTestSource(t *testing.T, models ...string) ModelDirectory {
+	directory := t.TempDir()
+	for i, model := range models {
+		_ = os.WriteFile(filepath.Join(directory, fmt.Sprintf("model%d.json", i)), []byte(model), 0644)
+	}
+
+	source := ModelDirectory{}
+	if err := source.Set(directory); err != nil {
+		t.Fatalf("Unable to create the test source: %s", err)
+	}
+	return source
+}
+
+func Test_uploadJournal_roundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upload.jsonl")
+
+	a := newTestEntry(t, `{"@id": "dtmi:test:a;1", "@type": "Interface"}`)
+	b := newTestEntry(t, `{"@id": "dtmi:test:b;1", "@type": "Interface", "extends": "dtmi:test:a;1"}`)
+	c := newTestEntry(t, `{"@id": "dtmi:test:c;1", "@type": "Interface", "extends": "dtmi:test:b;1"}`)
+	batches := [][]*modelEntry{{a}, {b}, {c}}
+
+	journal, err := createJournal(path, "https://example.digitaltwins.azure.net", "models", batches)
+	if err != nil {
+		t.Fatalf("Expected the journal to be created, but got error: %s", err)
+	}
+
+	if err = journal.commit(0); err != nil {
+		t.Fatalf("Expected the batch to be committed, but got error: %s", err)
+	}
+	_ = journal.close()
+
+	// Simulate the process being stopped part way through writing a record
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	_, _ = file.WriteString(`{"type": "commit", "bat`)
+	_ = file.Close()
+
+	resumed, err := readJournal(path)
+	if err != nil {
+		t.Fatalf("Expected the journal to be read, but got error: %s", err)
+	}
+
+	if resumed.endpoint != "https://example.digitaltwins.azure.net" || resumed.source != "models" {
+		t.Errorf("Expected the endpoint and source to be read, but got %s and %s", resumed.endpoint, resumed.source)
+	}
+
+	if len(resumed.batches) != 3 || resumed.batches[1][0] != "dtmi:test:b;1" {
+		t.Errorf("Expected the batches to be read, but got %v", resumed.batches)
+	}
+
+	if first := resumed.firstUncommitted(); first != 1 {
+		t.Errorf("Expected the first uncommitted batch to be 1, but got %d", first)
+	}
+}
+
+func Test_uploadJournal_resolve(t *testing.T) {
+	a := newTestEntry(t, `{"@id": "dtmi:test:a;1", "@type": "Interface"}`)
+	b := newTestEntry(t, `{"@id": "dtmi:test:b;1", "@type": "Interface", "extends": "dtmi:test:a;1"}`)
+	c := newTestEntry(t, `{"@id": "dtmi:test:c;1", "@type": "Interface", "extends": "dtmi:test:b;1"}`)
+	local := []*modelEntry{a, b, c}
+
+	newJournal := func() *uploadJournal {
+		return &uploadJournal{
+			batches:   [][]string{{"dtmi:test:a;1"}, {"dtmi:test:b;1"}, {"dtmi:test:c;1"}},
+			committed: map[int]bool{0: true},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		remote        []*modelEntry
+		expectedFirst int
+		expectedError string
+	}{
+		{"Continues after the committed batch", []*modelEntry{a}, 1, ""},
+		{"Treats uploaded batches as committed", []*modelEntry{a, b}, 2, ""},
+		{"Committed batch missing from the instance", []*modelEntry{}, 0, "model dtmi:test:a;1 in batch 1 was recorded as uploaded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			journal := newJournal()
+			batches, err := journal.resolve(local, tt.remote)
+
+			if len(tt.expectedError) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Errorf("Expected error containing '%s', but got: %v", tt.expectedError, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Expected the journal to be resolved, but got error: %s", err)
+			}
+
+			first := journal.firstUncommitted()
+			if first != tt.expectedFirst {
+				t.Errorf("Expected the first uncommitted batch to be %d, but got %d", tt.expectedFirst, first)
+			}
+
+			if batches[first][0] != local[first] {
+				t.Errorf("Expected batch %d to contain the local model %s", first, local[first].modelId)
+			}
+		})
+	}
+}
+
+func Test_uploadModels_journalWithoutCommits(t *testing.T) {
+	source := newTestSource(t,
+		`{"@id": "dtmi:test:a;1", "@type": "Interface"}`,
+		`{"@id": "dtmi:test:b;1", "@type": "Interface", "extends": "dtmi:test:a;1"}`,
+	)
+	journalPath := filepath.Join(t.TempDir(), "upload.jsonl")
+	options := UploadOptions{JournalPath: journalPath, MaxRequestBytes: 100}
+
+	instance := &fakeInstance{failUpload: 1}
+	client := newFakeInstanceClient(t, instance)
+
+	err := uploadModels(context.Background(), client, "https://example.api.weu.digitaltwins.azure.net", source, options)
+	if err == nil || strings.Contains(err.Error(), "-resume") {
+		t.Errorf("Expected the upload to fail without asking for it to be resumed, but got: %v", err)
+	}
+	if journalExists(journalPath) {
+		t.Errorf("Expected the journal to be removed as no batches were uploaded")
+	}
+
+	// A journal left without any commits, as happens if the process is stopped, does not stop a new upload
+	journal, _ := createJournal(journalPath, "https://example.api.weu.digitaltwins.azure.net", source.Path, nil)
+	_ = journal.close()
+
+	if err = uploadModels(context.Background(), client, "https://example.api.weu.digitaltwins.azure.net", source, options); err != nil {
+		t.Fatalf("Expected the upload to replace the journal, but got error: %s", err)
+	}
+	if ids := instance.modelIds(); ids != "dtmi:test:a;1,dtmi:test:b;1" {
+		t.Errorf("Expected both models to be uploaded, but the instance has %s", ids)
+	}
+	if journalExists(journalPath) {
+		t.Errorf("Expected the journal to be removed once the upload completed")
+	}
+}
+
+func Test_uploadModels_journalWithCommits(t *testing.T) {
+	source := newTestSource(t,
+		`{"@id": "dtmi:test:a;1", "@type": "Interface"}`,
+		`{"@id": "dtmi:test:b;1", "@type": "Interface", "extends": "dtmi:test:a;1"}`,
+	)
+	journalPath := filepath.Join(t.TempDir(), "upload.jsonl")
+	options := UploadOptions{JournalPath: journalPath, MaxRequestBytes: 100}
+
+	instance := &fakeInstance{failUpload: 2}
+	client := newFakeInstanceClient(t, instance)
+
+	err := uploadModels(context.Background(), client, "https://example.api.weu.digitaltwins.azure.net", source, options)
+	if err == nil || !strings.Contains(err.Error(), "run upload with -resume") {
+		t.Errorf("Expected the upload to fail and ask for it to be resumed, but got: %v", err)
+	}
+
+	err = uploadModels(context.Background(), client, "https://example.api.weu.digitaltwins.azure.net", source, options)
+	if err == nil || !strings.Contains(err.Error(), "a journal from an incomplete upload exists") {
+		t.Errorf("Expected a new upload to be refused while the journal records uploaded batches, but got: %v", err)
+	}
+
+	options.Resume = true
+	if err = uploadModels(context.Background(), client, "https://example.api.weu.digitaltwins.azure.net", source, options); err != nil {
+		t.Fatalf("Expected the upload to be resumed, but got error: %s", err)
+	}
+	if ids := instance.modelIds(); ids != "dtmi:test:a;1,dtmi:test:b;1" {
+		t.Errorf("Expected both models to be uploaded, but the instance has %s", ids)
+	}
+}
@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Layouts which can be used in place of a template when downloading models
+const (
+	LayoutDefault   = "default"   // A directory for each segment of the model id, e.g. dtmi/com/example/room_1.json
+	LayoutFlat      = "flat"      // A file for each model named after its model id, e.g. dtmi_com_example_room_1.json
+	LayoutNamespace = "namespace" // A file for each top level namespace holding all its models, e.g. com.json
+)
+
+// The templates used by each of the layouts
+var layoutTemplates = map[string]string{
+	LayoutDefault:   "{segments}/{name}_{version}.{ext}",
+	LayoutFlat:      "{dtmi}.{ext}",
+	LayoutNamespace: "{namespace}.{ext}",
+}
+
+// Matches the placeholders in a layout template
+var layoutPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// Matches the version placeholder along with the separator before it, which are both left out for ids with no version
+var layoutVersion = regexp.MustCompile(`[_.-]?\{version\}`)
+
+// The name used in place of a segment of the model id which is empty, such as the namespace of "dtmi:room;1"
+const emptySegmentName = "root"
+
+// downloadLayout decides which file each downloaded model is written to. The template is a slash separated path which
+// can use the following placeholders, taking "dtmi:com:Example:Room;2" as an example:
+//
+//	{segments}  the segments of the model id before its name, in lower case (dtmi/com/example)
+//	{namespace} the first segment after "dtmi", in lower case (com)
+//	{name}      the last segment of the model id (Room)
+//	{version}   the version of the model (2)
+//	{dtmi}      the whole model id with ':' and ';' replaced by '_' (dtmi_com_Example_Room_2)
+//	{ext}       the file extension
+//
+// For an id with no version, {version} and a '_', '-' or '.' before it are left out, so the default layout writes
+// "dtmi:com:Example:Room" to dtmi/com/example/Room.json. Segments which are empty, such as the namespace of an id with
+// a single segment after "dtmi", are written as "root"
+type downloadLayout struct {
+	template  string // The template used to create the path of each file
+	extension string // The file extension used for {ext}
+}
+
+// Creates the layout for a named layout or a template, checking that the template only uses known placeholders and
+// creates paths inside the output directory
+func newDownloadLayout(layout string, extension string) (*downloadLayout, error) {
+	template, ok := layoutTemplates[layout]
+	if !ok {
+		template = layout
+	}
+	if len(template) == 0 {
+		template = layoutTemplates[LayoutDefault]
+	}
+
+	for _, placeholder := range layoutPlaceholder.FindAllString(template, -1) {
+		switch placeholder {
+		case "{segments}", "{namespace}", "{name}", "{version}", "{dtmi}", "{ext}":
+		default:
+			return nil, fmt.Errorf("layout '%s' uses the unknown placeholder %s", template, placeholder)
+		}
+	}
+
+	if path.IsAbs(template) || strings.HasPrefix(path.Clean(template), "..") || strings.ContainsAny(layoutPlaceholder.ReplaceAllString(template, ""), "{}\\") {
+		return nil, fmt.Errorf("layout '%s' is not valid, it must be a relative path using '/' as the separator", template)
+	}
+
+	return &downloadLayout{template: template, extension: extension}, nil
+}
+
+// Gets the slash separated path of the file a model is written to. Parts of the path which are normally lower cased
+// are left as they are when lowerCase is not set, which is used to find models which only map to the same file because
+// of the lower casing
+func (layout *downloadLayout) path(modelId string, lowerCase bool) string {
+	lower := strings.ToLower
+	if !lowerCase {
+		lower = func(value string) string { return value }
+	}
+
+	base, version, hasVersion := strings.Cut(modelId, ";")
+	segments := strings.Split(base, ":")
+	namespace := ""
+	if len(segments) > 2 {
+		namespace = segments[1]
+	}
+
+	template := layout.template
+	if !hasVersion {
+		template = layoutVersion.ReplaceAllString(template, "")
+	}
+
+	replacer := strings.NewReplacer(
+		"{segments}", orEmptySegmentName(lower(strings.Join(segments[:len(segments)-1], "/"))),
+		"{namespace}", orEmptySegmentName(lower(namespace)),
+		"{name}", orEmptySegmentName(segments[len(segments)-1]),
+		"{version}", version,
+		"{dtmi}", strings.NewReplacer(":", "_", ";", "_").Replace(modelId),
+		"{ext}", layout.extension,
+	)
+
+	return path.Clean(replacer.Replace(template))
+}
+
+// Gets the value, or the name used for an empty segment if the value is empty
+func orEmptySegmentName(value string) string {
+	if len(value) == 0 {
+		return emptySegmentName
+	}
+	return value
+}
+
+// downloadFile is a file written by a download, holding one or more models
+type downloadFile struct {
+	path   string        // The slash separated path of the file, relative to the output directory
+	models []*modelEntry // The models written to the file
+}
+
+// Groups the models by the file they are written to, keeping the order of the models. Models whose ids only differ by
+// case are not allowed to share a file, or to be written to files whose paths only differ by case as they would
+// overwrite each other on a case-insensitive file system
+func (layout *downloadLayout) files(models []*modelEntry) ([]downloadFile, error) {
+	files := make([]downloadFile, 0)
+	byPath := make(map[string]int)
+	byLowerPath := make(map[string]string)
+	collisions := make([]string, 0)
+
+	for _, entry := range models {
+		filePath := layout.path(entry.modelId, true)
+
+		index, ok := byPath[filePath]
+		if !ok {
+			if existing, ok := byLowerPath[strings.ToLower(filePath)]; ok {
+				collisions = append(collisions, fmt.Sprintf("%s and %s only differ by case", existing, filePath))
+			}
+			byLowerPath[strings.ToLower(filePath)] = filePath
+
+			index = len(files)
+			byPath[filePath] = index
+			files = append(files, downloadFile{path: filePath})
+		}
+
+		// Models which share a file must also share it when nothing is lower cased
+		for _, other := range files[index].models {
+			if layout.path(other.modelId, false) != layout.path(entry.modelId, false) {
+				collisions = append(collisions, fmt.Sprintf("%s and %s would both be written to %s", other.modelId, entry.modelId, filePath))
+				break
+			}
+		}
+
+		files[index].models = append(files[index].models, entry)
+	}
+
+	if len(collisions) > 0 {
+		sort.Strings(collisions)
+		return nil, fmt.Errorf("models would overwrite each other because their ids only differ by case, use a different layout:\n  %s", strings.Join(collisions, "\n  "))
+	}
+
+	return files, nil
+}
@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_downloadLayout_path(t *testing.T) {
+	tests := []struct {
+		layout   string
+		expected string
+	}{
+		{"", "dtmi/com/example/Room_2.json"},
+		{LayoutDefault, "dtmi/com/example/Room_2.json"},
+		{LayoutFlat, "dtmi_com_Example_Room_2.json"},
+		{LayoutNamespace, "com.json"},
+		{"{namespace}/{name}.v{version}.{ext}", "com/Room.v2.json"},
+		{"models/all.json", "models/all.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.layout, func(t *testing.T) {
+			layout, err := newDownloadLayout(tt.layout, "json")
+			if err != nil {
+				t.Fatalf("Expected a valid layout, but got error: %s", err)
+			}
+
+			if actual := layout.path("dtmi:com:Example:Room;2", true); actual != tt.expected {
+				t.Errorf("Expected %s but got %s", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_downloadLayout_pathWithoutVersionOrNamespace(t *testing.T) {
+	tests := []struct {
+		layout   string
+		modelId  string
+		expected string
+	}{
+		{LayoutDefault, "dtmi:com:Example:Room", "dtmi/com/example/Room.json"},
+		{LayoutFlat, "dtmi:com:Example:Room", "dtmi_com_Example_Room.json"},
+		{"{namespace}/{name}-{version}.{ext}", "dtmi:com:Example:Room", "com/Room.json"},
+		{LayoutNamespace, "dtmi:room;1", "root.json"},
+		{"{namespace}/{name}_{version}.{ext}", "dtmi:room;1", "root/room_1.json"},
+		{LayoutDefault, "dtmi:com:Example:Room;2.1", "dtmi/com/example/Room_2.1.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.modelId+" "+tt.layout, func(t *testing.T) {
+			layout, err := newDownloadLayout(tt.layout, "json")
+			if err != nil {
+				t.Fatalf("Expected a valid layout, but got error: %s", err)
+			}
+
+			if actual := layout.path(tt.modelId, true); actual != tt.expected {
+				t.Errorf("Expected %s but got %s", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_newDownloadLayout_invalid(t *testing.T) {
+	tests := []struct {
+		layout        string
+		expectedError string
+	}{
+		{"{segments}/{model}.{ext}", "unknown placeholder {model}"},
+		{"/models/{name}.{ext}", "must be a relative path"},
+		{"../{name}.{ext}", "must be a relative path"},
+		{"{segments}\\{name}.{ext}", "must be a relative path"},
+		{"{name.{ext}", "must be a relative path"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.layout, func(t *testing.T) {
+			_, err := newDownloadLayout(tt.layout, "json")
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("Expected error containing '%s', but got: %v", tt.expectedError, err)
+			}
+		})
+	}
+}
+
+func Test_downloadLayout_files(t *testing.T) {
+	models := []*modelEntry{
+		newTestEntry(t, `{"@id": "dtmi:com:example:room;1", "@type": "Interface"}`),
+		newTestEntry(t, `{"@id": "dtmi:org:example:level;1", "@type": "Interface"}`),
+		newTestEntry(t, `{"@id": "dtmi:com:example:building;1", "@type": "Interface"}`),
+	}
+
+	layout, _ := newDownloadLayout(LayoutNamespace, "json")
+	files, err := layout.files(models)
+	if err != nil {
+		t.Fatalf("Expected the models to be grouped into files, but got error: %s", err)
+	}
+
+	if len(files) != 2 || files[0].path != "com.json" || joinModelIds(files[0].models) != "dtmi:com:example:room;1,dtmi:com:example:building;1" ||
+		files[1].path != "org.json" || joinModelIds(files[1].models) != "dtmi:org:example:level;1" {
+		t.Errorf("Unexpected files: %+v", files)
+	}
+}
+
+func Test_downloadLayout_files_collisions(t *testing.T) {
+	tests := []struct {
+		name          string
+		layout        string
+		ids           []string
+		expectedError string
+	}{
+		{"Lower cased segments", LayoutDefault, []string{"dtmi:com:Example:room;1", "dtmi:com:example:room;1"}, "dtmi:com:Example:room;1 and dtmi:com:example:room;1 would both be written to dtmi/com/example/room_1.json"},
+		{"Case-insensitive file system", LayoutDefault, []string{"dtmi:com:example:Room;1", "dtmi:com:example:room;1"}, "dtmi/com/example/Room_1.json and dtmi/com/example/room_1.json only differ by case"},
+		{"Lower cased namespaces", LayoutNamespace, []string{"dtmi:Com:example:room;1", "dtmi:com:example:level;1"}, "would both be written to com.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			models := make([]*modelEntry, len(tt.ids))
+			for i, id := range tt.ids {
+				models[i] = newTestEntry(t, `{"@id": "`+id+`", "@type": "Interface"}`)
+			}
+
+			layout, _ := newDownloadLayout(tt.layout, "json")
+			_, err := layout.files(models)
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("Expected error containing '%s', but got: %v", tt.expectedError, err)
+			}
+		})
+	}
+}
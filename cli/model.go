@@ -3,7 +3,9 @@ package cli
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"reflect"
+	"sort"
+	"strings"
 )
 
 type processingStatus int
@@ -27,7 +29,10 @@ func (object *jsonObject) getModelId() (*string, error) {
 			return nil, fmt.Errorf("unable to find 'id' or '@id' in the model")
 		}
 	}
-	id := idToken.(string)
+	id, ok := idToken.(string)
+	if !ok {
+		return nil, fmt.Errorf("the model id must be a string")
+	}
 	return &id, nil
 }
 
@@ -35,12 +40,21 @@ func (object *jsonObject) ToJson() ([]byte, error) {
 	return json.MarshalIndent(object, "", "  ")
 }
 
-// Represents a model entry, including its modelId, dependencies, and status
+// Checks if the object is semantically the same as another, ignoring the order of keys and any formatting
+func (object *jsonObject) equals(other jsonObject) bool {
+	return reflect.DeepEqual(*object, other)
+}
+
+// Represents a model entry, including its modelId and dependencies
 type modelEntry struct {
-	model        jsonObject       // The object of the model
-	modelId      string           // ID of the model
-	dependencies []*modelEntry    // References to other modelEntry instances which the current instance is dependent on
-	status       processingStatus // The processingStatus for the entry
+	model          jsonObject    // The object of the model
+	modelId        string        // ID of the model
+	dependencies   []*modelEntry // References to other modelEntry instances which the current instance extends, embeds as a component, or uses a schema from
+	targets        []*modelEntry // References to other modelEntry instances which are the target of a relationship on the current instance
+	decommissioned bool          // For models read from an instance, indicates if the model has been decommissioned
+	uploadTime     string        // For models read from an instance, when the model was uploaded
+	sourcePath     string        // For models read from local storage, the path of the file containing the model
+	sourceIndex    int           // For models read from a file holding more than one model, the position of the model in the file, otherwise -1
 }
 
 // Creates a new modelEntry instance based on a jsonObject
@@ -55,100 +69,415 @@ func newModelEntry(object jsonObject) (*modelEntry, error) {
 
 	entry.modelId = *modelId
 	entry.dependencies = make([]*modelEntry, 0)
-	entry.status = none
+	entry.targets = make([]*modelEntry, 0)
 
 	return entry, nil
 }
 
-// Gets the list of model IDs which the current modelEntry is dependent on
-func (entry *modelEntry) getModelDependencies() []string {
-	dependencies := make([]string, 0)
-	contents, ok := entry.model["contents"]
-	if ok {
-		componentsArr, ok := contents.([]interface{})
-		if ok {
-			for _, item := range componentsArr {
-				itemMap := item.(map[string]interface{})
-				itemType, ok := itemMap["@type"].(string)
-				if ok && itemType == "Component" {
-					schema, ok := itemMap["schema"].(string)
-					if ok {
-						dependencies = append(dependencies, schema)
-					}
-				}
+// Describes where the model was read from, for use in error messages. Models read from an instance are described by
+// their model id
+func (entry *modelEntry) location() string {
+	if len(entry.sourcePath) == 0 {
+		return entry.modelId
+	} else if entry.sourceIndex < 0 {
+		return fmt.Sprintf("%s (%s)", entry.modelId, entry.sourcePath)
+	}
+	return fmt.Sprintf("%s (model %d in %s)", entry.modelId, entry.sourceIndex+1, entry.sourcePath)
+}
+
+// Gets the list of references to other models which the current modelEntry is dependent on
+func (entry *modelEntry) getModelDependencies() []modelReference {
+	return getModelReferences(entry.model)
+}
+
+// Iterates over the collection of models and updates each one to hold a reference to its dependent models. References
+// to elements defined inside another model (such as a shared schema) are resolved to the model defining them
+func setModelDependencies(models []*modelEntry) {
+	definitions := modelDefinitions(models)
+
+	for _, entry := range models {
+		for _, reference := range entry.getModelDependencies() {
+			dependent, ok := definitions[reference.modelId]
+			if !ok || dependent == entry {
+				continue
+			}
+
+			if reference.kind == relationshipReference {
+				entry.targets = appendDistinct(entry.targets, dependent)
+			} else {
+				entry.dependencies = appendDistinct(entry.dependencies, dependent)
 			}
 		}
 	}
+}
 
-	extends, ok := entry.model["extends"]
-	if ok {
-		switch extends := extends.(type) {
-		case []interface{}:
-			items := make([]string, len(extends))
-			for i := range extends {
-				items[i] = extends[i].(string)
-			}
-			dependencies = append(dependencies, items...)
-		case interface{}:
-			dependencies = append(dependencies, extends.(string))
+// Maps the id of each model, and of each element defined inside a model, to the model which defines it
+func modelDefinitions(models []*modelEntry) map[string]*modelEntry {
+	definitions := make(map[string]*modelEntry)
+	for _, entry := range models {
+		for _, id := range getDefinedIds(entry.model) {
+			definitions[id] = entry
 		}
+		definitions[entry.modelId] = entry
+	}
+	return definitions
+}
+
+// Returns the models whose ids are in the given collection, keeping the order of the models
+func filterModels(models []*modelEntry, ids []string) []*modelEntry {
+	selected := make(map[string]bool)
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	results := make([]*modelEntry, 0, len(ids))
+	for _, entry := range models {
+		if selected[entry.modelId] {
+			results = append(results, entry)
+		}
+	}
+	return results
+}
+
+// Returns the models in reverse order, so that sorted models can be removed with their dependents removed first
+func reverseModels(models []*modelEntry) []*modelEntry {
+	reversed := make([]*modelEntry, len(models))
+
+	for i, j := len(models)-1, 0; i >= 0; i, j = i-1, j+1 {
+		reversed[j] = models[i]
 	}
 
-	check := make(map[string]bool)
-	var distinct []string
+	return reversed
+}
 
-	for _, str := range dependencies {
-		if _, ok := check[str]; !ok {
-			check[str] = true
-			distinct = append(distinct, str)
+// Appends the entry to the collection if it is not already present
+func appendDistinct(entries []*modelEntry, entry *modelEntry) []*modelEntry {
+	for _, existing := range entries {
+		if existing == entry {
+			return entries
 		}
 	}
+	return append(entries, entry)
+}
 
-	return distinct
+// CircularDependencyError is returned when the models being sorted contain one or more circular dependencies. Each
+// cycle is held as the ordered list of model ids which form it, with the first model repeated at the end
+type CircularDependencyError struct {
+	Cycles [][]string // The model ids making up each of the detected cycles
 }
 
-// Iterates over the collection of models and updates each one to hold a reference to its dependent models
-func setModelDependencies(models []*modelEntry) {
+// Error returns the string representation of the error, listing each of the cycles found
+func (e *CircularDependencyError) Error() string {
+	var builder strings.Builder
+	_, _ = fmt.Fprintf(&builder, "detected %d circular dependency chain(s) between models:", len(e.Cycles))
+	for _, cycle := range e.Cycles {
+		builder.WriteString("\n  ")
+		builder.WriteString(strings.Join(cycle, " -> "))
+	}
+	return builder.String()
+}
+
+// Returns a collection of models which have been sorted topologically. If any circular dependencies are found then
+// a CircularDependencyError is returned listing all of them
+func sortModels(models []*modelEntry) ([]*modelEntry, error) {
+	groups, err := sortModelGroups(models)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*modelEntry, 0, len(models))
+	for _, group := range groups {
+		results = append(results, group...)
+	}
+
+	return results, nil
+}
+
+// Returns the models grouped and sorted topologically. Relationships are allowed to form cycles (e.g. a building which
+// has levels, and a level which is part of a building), and so models which target each other through relationships
+// are placed in the same group as they must be created together. Models in a group are sorted so that any model they
+// extend, embed or use a schema from comes first. Cycles through extends, components or schemas are not valid DTDL and
+// result in a CircularDependencyError
+func sortModelGroups(models []*modelEntry) ([][]*modelEntry, error) {
+	sorter := topologicalSorter{
+		status:  make(map[*modelEntry]processingStatus),
+		results: make([]*modelEntry, 0),
+	}
+
+	for _, entry := range models {
+		sorter.visit(entry)
+	}
+
+	if len(sorter.cycles) > 0 {
+		return nil, &CircularDependencyError{Cycles: sorter.cycles}
+	}
+
+	rank := make(map[*modelEntry]int)
+	for i, entry := range sorter.results {
+		rank[entry] = i
+	}
+
+	groups := findStronglyConnected(models)
+	for _, group := range groups {
+		sort.SliceStable(group, func(i, j int) bool { return rank[group[i]] < rank[group[j]] })
+	}
+
+	return groups, nil
+}
+
+// Holds the state of a topological sort whilst the models are being visited
+type topologicalSorter struct {
+	status  map[*modelEntry]processingStatus // The processingStatus of each entry
+	results []*modelEntry                    // The sorted models
+	path    []*modelEntry                    // The models currently being visited, in the order they were reached
+	cycles  [][]string                       // Any circular dependencies found whilst visiting the models
+}
+
+// Visits a specific modelEntry and ensures it and it's dependencies are added to the sorted collection. When a model
+// which is still being processed is reached again, the chain of models leading back to it is recorded as a cycle
+func (sorter *topologicalSorter) visit(entry *modelEntry) {
+	if sorter.status[entry] == processing {
+		sorter.recordCycle(entry)
+		return
+	} else if sorter.status[entry] == processed {
+		return
+	}
+
+	sorter.status[entry] = processing
+	sorter.path = append(sorter.path, entry)
+
+	for _, dependency := range entry.dependencies {
+		sorter.visit(dependency)
+	}
+
+	sorter.path = sorter.path[:len(sorter.path)-1]
+	sorter.status[entry] = processed
+	sorter.results = append(sorter.results, entry)
+}
+
+// Records the cycle which starts and ends with the given entry, using the current path of visited models
+func (sorter *topologicalSorter) recordCycle(entry *modelEntry) {
+	start := 0
+	for i := range sorter.path {
+		if sorter.path[i] == entry {
+			start = i
+			break
+		}
+	}
+
+	cycle := make([]string, 0, len(sorter.path)-start+1)
+	for _, item := range sorter.path[start:] {
+		cycle = append(cycle, item.modelId)
+	}
+	cycle = append(cycle, entry.modelId)
+
+	sorter.cycles = append(sorter.cycles, cycle)
+}
+
+// Holds the state of Tarjan's strongly connected components algorithm over both dependencies and relationship targets
+type componentFinder struct {
+	index   map[*modelEntry]int  // The order in which each entry was first visited
+	lowLink map[*modelEntry]int  // The lowest index reachable from each entry
+	onStack map[*modelEntry]bool // Indicates if the entry is currently on the stack
+	stack   []*modelEntry        // Entries visited which have not yet been assigned to a component
+	groups  [][]*modelEntry      // The components found, with each component following those it depends on
+}
+
+// Finds the strongly connected components of the model graph. Components are returned in dependency order, so any
+// component comes after all the components it references
+func findStronglyConnected(models []*modelEntry) [][]*modelEntry {
+	finder := componentFinder{
+		index:   make(map[*modelEntry]int),
+		lowLink: make(map[*modelEntry]int),
+		onStack: make(map[*modelEntry]bool),
+		groups:  make([][]*modelEntry, 0),
+	}
+
 	for _, entry := range models {
-		for _, dependencyId := range entry.getModelDependencies() {
-			for _, dependent := range models {
-				if dependencyId == dependent.modelId {
-					entry.dependencies = append(entry.dependencies, dependent)
+		if _, visited := finder.index[entry]; !visited {
+			finder.connect(entry)
+		}
+	}
+
+	return finder.groups
+}
+
+// Visits the entry and all entries reachable from it, collecting any components which are completed
+func (finder *componentFinder) connect(entry *modelEntry) {
+	finder.index[entry] = len(finder.index)
+	finder.lowLink[entry] = finder.index[entry]
+	finder.stack = append(finder.stack, entry)
+	finder.onStack[entry] = true
+
+	for _, edges := range [][]*modelEntry{entry.dependencies, entry.targets} {
+		for _, next := range edges {
+			if _, visited := finder.index[next]; !visited {
+				finder.connect(next)
+				if finder.lowLink[next] < finder.lowLink[entry] {
+					finder.lowLink[entry] = finder.lowLink[next]
 				}
+			} else if finder.onStack[next] && finder.index[next] < finder.lowLink[entry] {
+				finder.lowLink[entry] = finder.index[next]
 			}
 		}
 	}
+
+	if finder.lowLink[entry] != finder.index[entry] {
+		return
+	}
+
+	group := make([]*modelEntry, 0)
+	for {
+		member := finder.stack[len(finder.stack)-1]
+		finder.stack = finder.stack[:len(finder.stack)-1]
+		finder.onStack[member] = false
+		group = append(group, member)
+		if member == entry {
+			break
+		}
+	}
+	finder.groups = append(finder.groups, group)
 }
 
-// Returns a collection of models which have been sorted topologically
-func sortModels(models []*modelEntry) []*modelEntry {
-	results := make([]*modelEntry, 0)
+// Groups the models into levels which can be deleted in order. The first level holds the models which no other model in
+// the collection depends on, and each following level holds the models whose dependents are all in earlier levels, so
+// the models in a level can be deleted at the same time once the earlier levels have been deleted. Only dependencies
+// between models in the collection are considered, and relationship targets are ignored as they do not prevent a model
+// from being deleted. If the dependencies contain a cycle then a CircularDependencyError is returned
+func deletionLevels(models []*modelEntry) ([][]*modelEntry, error) {
+	included := make(map[*modelEntry]bool)
+	for _, entry := range models {
+		included[entry] = true
+	}
+
+	dependents := make(map[*modelEntry]int)
+	for _, entry := range models {
+		for _, dependency := range entry.dependencies {
+			if included[dependency] {
+				dependents[dependency]++
+			}
+		}
+	}
+
+	levels := make([][]*modelEntry, 0)
+	remaining := models
+	for len(remaining) > 0 {
+		level := make([]*modelEntry, 0)
+		blocked := make([]*modelEntry, 0)
+		for _, entry := range remaining {
+			if dependents[entry] == 0 {
+				level = append(level, entry)
+			} else {
+				blocked = append(blocked, entry)
+			}
+		}
+
+		if len(level) == 0 {
+			_, err := sortModels(blocked)
+			if err == nil {
+				err = fmt.Errorf("unable to determine the order to delete %d model(s) in", len(blocked))
+			}
+			return nil, err
+		}
 
+		for _, entry := range level {
+			for _, dependency := range entry.dependencies {
+				if included[dependency] {
+					dependents[dependency]--
+				}
+			}
+		}
+
+		levels = append(levels, level)
+		remaining = blocked
+	}
+
+	return levels, nil
+}
+
+// Finds every model which depends on any of the selected models, directly or through other models, by extending it,
+// embedding it as a component, or using a schema from it. The selected models are not included in the results, which
+// keep the order of the models
+func findDependents(models []*modelEntry, selected []*modelEntry) []*modelEntry {
+	dependents := make(map[*modelEntry][]*modelEntry)
 	for _, entry := range models {
-		if entry.status == processed {
-			continue
+		for _, dependency := range entry.dependencies {
+			dependents[dependency] = append(dependents[dependency], entry)
 		}
+	}
 
-		visit(entry, &results)
+	found := make(map[*modelEntry]bool)
+	for _, entry := range selected {
+		found[entry] = true
 	}
 
+	queue := make([]*modelEntry, len(selected))
+	copy(queue, selected)
+	for len(queue) > 0 {
+		entry := queue[0]
+		queue = queue[1:]
+
+		for _, dependent := range dependents[entry] {
+			if !found[dependent] {
+				found[dependent] = true
+				queue = append(queue, dependent)
+			}
+		}
+	}
+
+	for _, entry := range selected {
+		delete(found, entry)
+	}
+
+	results := make([]*modelEntry, 0, len(found))
+	for _, entry := range models {
+		if found[entry] {
+			results = append(results, entry)
+		}
+	}
 	return results
 }
 
-// Visits a specific modelEntry and ensures it and it's dependencies are added to the sorted collection
-func visit(entry *modelEntry, results *[]*modelEntry) {
-	if entry.status == processing {
-		log.Fatalf("Detected a circular dependency for model %s", entry.modelId)
-	} else if entry.status == processed {
-		return
+// DependentModelsError is returned when models cannot be removed because other models which are not being removed
+// depend on them
+type DependentModelsError struct {
+	Dependents map[string][]string // For each dependent model, the ids of the models being removed which it depends on
+	Hint       string              // Describes how the dependent models can be removed as well
+}
+
+// Creates a DependentModelsError for the dependents, recording which of the models being removed each one depends on
+func newDependentModelsError(dependents []*modelEntry, removing []*modelEntry) *DependentModelsError {
+	included := make(map[*modelEntry]bool)
+	for _, entry := range removing {
+		included[entry] = true
+	}
+
+	err := DependentModelsError{Dependents: make(map[string][]string), Hint: "use -cascade to remove them as well"}
+	for _, entry := range dependents {
+		dependencies := make([]string, 0)
+		for _, dependency := range entry.dependencies {
+			if included[dependency] {
+				dependencies = append(dependencies, dependency.modelId)
+			}
+		}
+		err.Dependents[entry.modelId] = dependencies
 	}
 
-	entry.status = processing
+	return &err
+}
 
-	for _, dependency := range entry.dependencies {
-		visit(dependency, results)
+// Error returns the string representation of the error, listing each dependent model and what it depends on
+func (e *DependentModelsError) Error() string {
+	ids := make([]string, 0, len(e.Dependents))
+	for id := range e.Dependents {
+		ids = append(ids, id)
 	}
+	sort.Strings(ids)
 
-	entry.status = processed
-	*results = append(*results, entry)
+	var builder strings.Builder
+	_, _ = fmt.Fprintf(&builder, "%d other model(s) depend on the models being removed, %s:", len(ids), e.Hint)
+	for _, id := range ids {
+		_, _ = fmt.Fprintf(&builder, "\n  %s -> %s", id, strings.Join(e.Dependents[id], ", "))
+	}
+	return builder.String()
 }
@@ -2,8 +2,9 @@ package cli
 
 import (
 	"encoding/json"
+	"errors"
 	"os"
-	"os/exec"
+	"strings"
 	"testing"
 )
 
@@ -15,14 +16,73 @@ func Test_modelEntry_getModelDependencies(t *testing.T) {
 	entry, _ := newModelEntry(jsonContent)
 	dependencies := entry.getModelDependencies()
 
-	if len(dependencies) != 1 {
-		t.Fatalf("Expected 1 dependency, but got %d", len(dependencies))
+	expected := []modelReference{
+		{modelId: "dtmi:digitaltwins:testing:core:level;1", kind: relationshipReference},
+		{modelId: "dtmi:digitaltwins:testing:core:space;1", kind: extendsReference},
 	}
 
-	expectedDependency := "dtmi:digitaltwins:testing:core:space;1"
+	if len(dependencies) != len(expected) {
+		t.Fatalf("Expected %d dependencies, but got %d", len(expected), len(dependencies))
+	}
+
+	for _, reference := range expected {
+		found := false
+		for _, dependency := range dependencies {
+			if dependency.modelId == reference.modelId && dependency.kind == reference.kind {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Dependency of '%s' (%s) not found", reference.modelId, reference.kind)
+		}
+	}
+}
+
+func Test_modelEntry_getModelDependencies_nested(t *testing.T) {
+	fileContent, _ := os.ReadFile("../testdata/dependencies/thermostat.json")
+	var jsonContent jsonObject
+	_ = json.Unmarshal(fileContent, &jsonContent)
+
+	entry, _ := newModelEntry(jsonContent)
+	dependencies := entry.getModelDependencies()
 
-	if dependencies[0] != expectedDependency {
-		t.Fatalf("Dependency of '%s' not found, instead got '%s'", expectedDependency, dependencies[0])
+	expected := []modelReference{
+		{modelId: "dtmi:digitaltwins:testing:devices:device;1", kind: extendsReference},
+		{modelId: "dtmi:digitaltwins:testing:devices:controllable;1", kind: extendsReference},
+		{modelId: "dtmi:digitaltwins:testing:devices:sensor;1", kind: componentReference},
+		{modelId: "dtmi:digitaltwins:testing:devices:screen;1", kind: extendsReference},
+		{modelId: "dtmi:digitaltwins:testing:core:room;1", kind: relationshipReference},
+		{modelId: "dtmi:digitaltwins:testing:devices:placement;1", kind: schemaReference},
+		{modelId: "dtmi:digitaltwins:testing:devices:rebootOptions;1", kind: schemaReference},
+		{modelId: "dtmi:digitaltwins:testing:devices:mode;1", kind: schemaReference, pointer: "/schemas/0/fields/0/schema"},
+	}
+
+	if len(dependencies) != len(expected) {
+		t.Fatalf("Expected %d dependencies, but got %d: %v", len(expected), len(dependencies), dependencies)
+	}
+
+	for i := range expected {
+		if dependencies[i].modelId != expected[i].modelId || dependencies[i].kind != expected[i].kind {
+			t.Errorf("Expected dependency %d to be '%s' (%s), but got '%s' (%s)", i, expected[i].modelId, expected[i].kind, dependencies[i].modelId, dependencies[i].kind)
+		}
+	}
+
+	last := dependencies[len(dependencies)-1]
+	if last.pointer != expected[len(expected)-1].pointer {
+		t.Errorf("Expected reference to be found at '%s', but got '%s'", expected[len(expected)-1].pointer, last.pointer)
+	}
+}
+
+func Test_modelEntry_getModelDependencies_inlineExtends(t *testing.T) {
+	entry, _ := newModelEntry(jsonObject{
+		"@id":     "dtmi:digitaltwins:testing:inline;1",
+		"@type":   "Interface",
+		"extends": map[string]interface{}{"@id": "dtmi:digitaltwins:testing:inline:base;1", "@type": "Interface"},
+	})
+
+	dependencies := entry.getModelDependencies()
+	if len(dependencies) != 0 {
+		t.Fatalf("Expected no dependencies for an inline interface, but got %v", dependencies)
 	}
 }
 
@@ -60,7 +120,11 @@ func Test_sortModels(t *testing.T) {
 
 	setModelDependencies(models)
 
-	sorted := sortModels(models)
+	sorted, err := sortModels(models)
+	if err != nil {
+		t.Fatalf("Expected models to be sorted, but received error: %s", err)
+	}
+
 	expectedSize := 5
 	if len(sorted) != expectedSize {
 		t.Fatalf("Expected a collection of %d elements, but got %d", expectedSize, len(sorted))
@@ -91,34 +155,199 @@ func Test_sortModels(t *testing.T) {
 }
 
 func Test_sortModels_circular(t *testing.T) {
-	if os.Getenv("TEST_CRASHER") == "1" {
-		d := ModelDirectory{}
-		_ = d.Set("../testdata/models")
-		models, _ := d.getModels()
+	d := ModelDirectory{}
+	_ = d.Set("../testdata/models")
+	models, _ := d.getModels()
 
-		setModelDependencies(models)
+	setModelDependencies(models)
 
-		// Create a circular dependency
-		var roomEntry, meetingRoomEntry *modelEntry
+	// Create a circular dependency
+	var roomEntry, meetingRoomEntry *modelEntry
 
-		for _, entry := range models {
-			if entry.modelId == "dtmi:digitaltwins:testing:core:room;1" {
-				roomEntry = entry
-			} else if entry.modelId == "dtmi:digitaltwins:testing:core:meetingroom;1" {
-				meetingRoomEntry = entry
-			}
+	for _, entry := range models {
+		if entry.modelId == "dtmi:digitaltwins:testing:core:room;1" {
+			roomEntry = entry
+		} else if entry.modelId == "dtmi:digitaltwins:testing:core:meetingroom;1" {
+			meetingRoomEntry = entry
 		}
+	}
+
+	roomEntry.dependencies = append(roomEntry.dependencies, meetingRoomEntry)
+	_, err := sortModels(models)
 
-		roomEntry.dependencies = append(roomEntry.dependencies, meetingRoomEntry)
-		_ = sortModels(models)
+	var cycleErr *CircularDependencyError
+	if !errors.As(err, &cycleErr) {
+		t.Fatalf("Expected a CircularDependencyError, but got %v", err)
 	}
 
-	cmd := exec.Command(os.Args[0], "-test.run=Test_sortModels_circular")
-	cmd.Env = append(os.Environ(), "TEST_CRASHER=1")
-	err := cmd.Run()
-	if e, ok := err.(*exec.ExitError); ok && !e.Success() {
-		return
+	if len(cycleErr.Cycles) != 1 {
+		t.Fatalf("Expected 1 cycle, but got %d", len(cycleErr.Cycles))
 	}
 
-	t.Fatalf("process ran with error %v, but wanted exit status 1", err)
+	cycle := strings.Join(cycleErr.Cycles[0], " -> ")
+	expectedCycles := []string{
+		"dtmi:digitaltwins:testing:core:room;1 -> dtmi:digitaltwins:testing:core:meetingroom;1 -> dtmi:digitaltwins:testing:core:room;1",
+		"dtmi:digitaltwins:testing:core:meetingroom;1 -> dtmi:digitaltwins:testing:core:room;1 -> dtmi:digitaltwins:testing:core:meetingroom;1",
+	}
+	if cycle != expectedCycles[0] && cycle != expectedCycles[1] {
+		t.Fatalf("Unexpected cycle reported: %s", cycle)
+	}
+}
+
+func Test_sortModelGroups_relationshipCycle(t *testing.T) {
+	newEntry := func(id string) *modelEntry {
+		entry, _ := newModelEntry(jsonObject{"@id": id})
+		return entry
+	}
+
+	// a building which has levels, and a level which is part of a building, where both extend a space
+	space, building, level := newEntry("space"), newEntry("building"), newEntry("level")
+	building.dependencies = []*modelEntry{space}
+	level.dependencies = []*modelEntry{space}
+	building.targets = []*modelEntry{level}
+	level.targets = []*modelEntry{building}
+
+	groups, err := sortModelGroups([]*modelEntry{building, level, space})
+	if err != nil {
+		t.Fatalf("Expected relationship cycles to be allowed, but got error: %s", err)
+	}
+
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 groups, but got %d", len(groups))
+	}
+
+	if len(groups[0]) != 1 || groups[0][0] != space {
+		t.Fatalf("Expected the first group to only contain the space model")
+	}
+
+	if len(groups[1]) != 2 {
+		t.Fatalf("Expected the building and level to be grouped together, but got %d model(s)", len(groups[1]))
+	}
+}
+
+func Test_sortModels_multipleCycles(t *testing.T) {
+	newEntry := func(id string) *modelEntry {
+		entry, _ := newModelEntry(jsonObject{"@id": id})
+		return entry
+	}
+
+	a, b, c, d, e := newEntry("a"), newEntry("b"), newEntry("c"), newEntry("d"), newEntry("e")
+	a.dependencies = []*modelEntry{b}
+	b.dependencies = []*modelEntry{c}
+	c.dependencies = []*modelEntry{a}
+	d.dependencies = []*modelEntry{e}
+	e.dependencies = []*modelEntry{d}
+
+	_, err := sortModels([]*modelEntry{a, b, c, d, e})
+
+	var cycleErr *CircularDependencyError
+	if !errors.As(err, &cycleErr) {
+		t.Fatalf("Expected a CircularDependencyError, but got %v", err)
+	}
+
+	expected := []string{"a -> b -> c -> a", "d -> e -> d"}
+	if len(cycleErr.Cycles) != len(expected) {
+		t.Fatalf("Expected %d cycles, but got %d", len(expected), len(cycleErr.Cycles))
+	}
+
+	for i := range expected {
+		if actual := strings.Join(cycleErr.Cycles[i], " -> "); actual != expected[i] {
+			t.Errorf("Expected cycle '%s', but got '%s'", expected[i], actual)
+		}
+	}
+}
+
+func Test_deletionLevels(t *testing.T) {
+	space := newTestEntry(t, `{"@id": "dtmi:test:space;1", "@type": "Interface"}`)
+	room := newTestEntry(t, `{"@id": "dtmi:test:room;1", "@type": "Interface", "extends": "dtmi:test:space;1"}`)
+	level := newTestEntry(t, `{"@id": "dtmi:test:level;1", "@type": "Interface", "extends": "dtmi:test:space;1", "contents": [{"@type": "Relationship", "name": "rooms", "target": "dtmi:test:room;1"}]}`)
+	meetingRoom := newTestEntry(t, `{"@id": "dtmi:test:meetingroom;1", "@type": "Interface", "extends": "dtmi:test:room;1"}`)
+	models := []*modelEntry{space, room, level, meetingRoom}
+
+	setModelDependencies(models)
+
+	levels, err := deletionLevels(models)
+	if err != nil {
+		t.Fatalf("Expected deletion levels to be found, but received error: %s", err)
+	}
+
+	expected := [][]string{
+		{"dtmi:test:level;1", "dtmi:test:meetingroom;1"},
+		{"dtmi:test:room;1"},
+		{"dtmi:test:space;1"},
+	}
+
+	if len(levels) != len(expected) {
+		t.Fatalf("Expected %d levels, but got %d", len(expected), len(levels))
+	}
+
+	for i := range expected {
+		ids := make([]string, 0, len(levels[i]))
+		for _, entry := range levels[i] {
+			ids = append(ids, entry.modelId)
+		}
+		if strings.Join(ids, ",") != strings.Join(expected[i], ",") {
+			t.Errorf("Expected level %d to contain %v, but got %v", i, expected[i], ids)
+		}
+	}
+}
+
+func Test_deletionLevels_subset(t *testing.T) {
+	space := newTestEntry(t, `{"@id": "dtmi:test:space;1", "@type": "Interface"}`)
+	room := newTestEntry(t, `{"@id": "dtmi:test:room;1", "@type": "Interface", "extends": "dtmi:test:space;1"}`)
+	meetingRoom := newTestEntry(t, `{"@id": "dtmi:test:meetingroom;1", "@type": "Interface", "extends": "dtmi:test:room;1"}`)
+
+	setModelDependencies([]*modelEntry{space, room, meetingRoom})
+
+	// Dependents which are not being deleted are not considered
+	levels, err := deletionLevels([]*modelEntry{space, room})
+	if err != nil {
+		t.Fatalf("Expected deletion levels to be found, but received error: %s", err)
+	}
+
+	if len(levels) != 2 || levels[0][0] != room || levels[1][0] != space {
+		t.Errorf("Expected the room model to be deleted before the space model")
+	}
+}
+
+func Test_deletionLevels_circular(t *testing.T) {
+	a := newTestEntry(t, `{"@id": "dtmi:test:a;1", "@type": "Interface", "extends": "dtmi:test:b;1"}`)
+	b := newTestEntry(t, `{"@id": "dtmi:test:b;1", "@type": "Interface", "extends": "dtmi:test:a;1"}`)
+	models := []*modelEntry{a, b}
+
+	setModelDependencies(models)
+
+	_, err := deletionLevels(models)
+
+	var circularErr *CircularDependencyError
+	if !errors.As(err, &circularErr) {
+		t.Fatalf("Expected a CircularDependencyError, but got: %v", err)
+	}
+}
+
+func Test_findDependents(t *testing.T) {
+	space := newTestEntry(t, `{"@id": "dtmi:test:space;1", "@type": "Interface"}`)
+	room := newTestEntry(t, `{"@id": "dtmi:test:room;1", "@type": "Interface", "extends": "dtmi:test:space;1"}`)
+	meetingRoom := newTestEntry(t, `{"@id": "dtmi:test:meetingroom;1", "@type": "Interface", "extends": "dtmi:test:room;1"}`)
+	level := newTestEntry(t, `{"@id": "dtmi:test:level;1", "@type": "Interface", "contents": [{"@type": "Relationship", "name": "rooms", "target": "dtmi:test:room;1"}]}`)
+	sensor := newTestEntry(t, `{"@id": "dtmi:test:sensor;1", "@type": "Interface"}`)
+	models := []*modelEntry{space, room, meetingRoom, level, sensor}
+
+	setModelDependencies(models)
+
+	dependents := findDependents(models, []*modelEntry{room})
+	if len(dependents) != 1 || dependents[0] != meetingRoom {
+		t.Errorf("Expected only the meeting room model to depend on the room model, but got %d model(s)", len(dependents))
+	}
+
+	dependents = findDependents(models, []*modelEntry{space})
+	if len(dependents) != 2 || dependents[0] != room || dependents[1] != meetingRoom {
+		t.Errorf("Expected the room and meeting room models to depend on the space model, but got %d model(s)", len(dependents))
+	}
+
+	err := newDependentModelsError(dependents, []*modelEntry{space, room, meetingRoom})
+	expected := "2 other model(s) depend on the models being removed, use -cascade to remove them as well:\n  dtmi:test:meetingroom;1 -> dtmi:test:room;1\n  dtmi:test:room;1 -> dtmi:test:space;1"
+	if err.Error() != expected {
+		t.Errorf("Unexpected error message:\n%s", err)
+	}
 }
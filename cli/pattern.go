@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Checks if the value contains any glob wildcards, and so should be matched as a pattern rather than a model id
+func isModelPattern(value string) bool {
+	return strings.ContainsAny(value, "*?[")
+}
+
+// Finds the ids of the models which match any of the patterns, keeping the order of the models. Patterns are either
+// model ids or globs (e.g. "dtmi:com:example:*;1") where '*' matches any sequence of characters and '?' matches any
+// single character. An error is returned if a pattern is not valid or does not match any models
+func matchModelIds(patterns []string, models []*modelEntry) ([]string, error) {
+	selected := make(map[string]bool)
+
+	for _, pattern := range patterns {
+		found := false
+		for _, entry := range models {
+			matched, err := matchModelId(pattern, entry.modelId)
+			if err != nil {
+				return nil, fmt.Errorf("'%s' is not a valid pattern: %s", pattern, err)
+			}
+			if matched {
+				selected[entry.modelId] = true
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("no models in the instance match '%s'", pattern)
+		}
+	}
+
+	ids := make([]string, 0, len(selected))
+	for _, entry := range models {
+		if selected[entry.modelId] {
+			ids = append(ids, entry.modelId)
+			delete(selected, entry.modelId)
+		}
+	}
+
+	return ids, nil
+}
+
+// Checks if a model id matches a pattern. Model ids cannot contain a '/', and so the pattern is matched against the
+// whole id
+func matchModelId(pattern string, modelId string) (bool, error) {
+	if !isModelPattern(pattern) {
+		return pattern == modelId, nil
+	}
+	return path.Match(pattern, modelId)
+}
+
+// Reads model ids or patterns from a file, one per line. Blank lines and lines starting with '#' are ignored
+func readModelPatterns(filePath string) ([]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	patterns := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return patterns, nil
+}
@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_matchModelIds(t *testing.T) {
+	models := []*modelEntry{
+		newTestEntry(t, `{"@id": "dtmi:com:example:building;1", "@type": "Interface"}`),
+		newTestEntry(t, `{"@id": "dtmi:com:example:level;1", "@type": "Interface"}`),
+		newTestEntry(t, `{"@id": "dtmi:com:example:level;2", "@type": "Interface"}`),
+		newTestEntry(t, `{"@id": "dtmi:com:other:room;1", "@type": "Interface"}`),
+	}
+
+	tests := []struct {
+		name          string
+		patterns      []string
+		expected      []string
+		expectedError string
+	}{
+		{"Model id", []string{"dtmi:com:example:level;1"}, []string{"dtmi:com:example:level;1"}, ""},
+		{"Wildcard", []string{"dtmi:com:example:*;1"}, []string{"dtmi:com:example:building;1", "dtmi:com:example:level;1"}, ""},
+		{"Single character", []string{"dtmi:com:example:level;?"}, []string{"dtmi:com:example:level;1", "dtmi:com:example:level;2"}, ""},
+		{"Overlapping patterns", []string{"dtmi:com:other:*", "dtmi:com:*:room;1"}, []string{"dtmi:com:other:room;1"}, ""},
+		{"No match", []string{"dtmi:com:missing;1"}, nil, "no models in the instance match 'dtmi:com:missing;1'"},
+		{"Invalid pattern", []string{"dtmi:com:[example"}, nil, "is not a valid pattern"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, err := matchModelIds(tt.patterns, models)
+
+			if len(tt.expectedError) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Errorf("Expected error containing '%s', but got: %v", tt.expectedError, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Expected models to match, but got error: %s", err)
+			}
+
+			if strings.Join(ids, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected %v but got %v", tt.expected, ids)
+			}
+		})
+	}
+}
+
+func Test_readModelPatterns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models.txt")
+	content := "# Models being retired\ndtmi:com:example:level;1\n\n  dtmi:com:other:*  \n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	patterns, err := readModelPatterns(path)
+	if err != nil {
+		t.Fatalf("Expected patterns to be read, but got error: %s", err)
+	}
+
+	expected := []string{"dtmi:com:example:level;1", "dtmi:com:other:*"}
+	if strings.Join(patterns, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v but got %v", expected, patterns)
+	}
+}
@@ -0,0 +1,266 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const planFormatVersion = 1 // Version of the serialized execution plan format
+
+type planAction string
+
+const (
+	createAction       planAction = "create"       // Upload a batch of models
+	decommissionAction planAction = "decommission" // Decommission models which are no longer in the source
+	deleteAction       planAction = "delete"       // Delete models which are no longer in the source
+)
+
+// Values allowed for how models which only exist in the instance should be handled by a plan
+const (
+	OrphanDelete       = "delete"       // Delete the orphaned models
+	OrphanDecommission = "decommission" // Decommission the orphaned models
+	OrphanKeep         = "keep"         // Leave the orphaned models untouched
+)
+
+// executionPlan is the ordered set of operations needed to make an Azure Digital Twin instance match a set of local
+// models. It is serialized so that it can be reviewed before being applied
+type executionPlan struct {
+	Version     int        `json:"version"`            // Version of the plan format
+	Endpoint    string     `json:"endpoint"`           // The instance the plan was created for
+	Source      string     `json:"source"`             // The location of the models the plan was created from
+	CreatedAt   time.Time  `json:"createdAt"`          // When the plan was created
+	Fingerprint string     `json:"fingerprint"`        // Fingerprint of the models in the instance when the plan was created
+	Steps       []planStep `json:"steps"`              // The operations to perform, in order
+	Retained    []string   `json:"retained,omitempty"` // Orphaned models kept as models which are not being removed depend on them
+}
+
+// planStep is a single operation in an executionPlan
+type planStep struct {
+	Action   planAction   `json:"action"`           // The action to perform
+	ModelIds []string     `json:"modelIds"`         // The models the action applies to, in the order they are processed
+	Models   []jsonObject `json:"models,omitempty"` // For create steps, the definitions of the models to upload
+}
+
+// Counts the number of models affected by steps with the given action
+func (plan *executionPlan) count(action planAction) int {
+	count := 0
+	for _, step := range plan.Steps {
+		if step.Action == action {
+			count += len(step.ModelIds)
+		}
+	}
+	return count
+}
+
+// Builds the plan needed to make the remote models match the local models. Models are created in dependency order,
+// in batches within the limits, as an upload would, and orphaned models are then decommissioned or deleted according
+// to the orphans value. Orphaned models which are still depended on by models being kept are retained
+func buildPlan(local []*modelEntry, remote []*modelEntry, orphans string, limits batchLimits) (*executionPlan, error) {
+	if orphans != OrphanDelete && orphans != OrphanDecommission && orphans != OrphanKeep {
+		return nil, fmt.Errorf("orphan action '%s' is not valid, only '%s', '%s' or '%s' should be provided", orphans, OrphanDelete, OrphanDecommission, OrphanKeep)
+	}
+
+	diff := diffModels(local, remote)
+	if err := diff.conflictError(); err != nil {
+		return nil, err
+	}
+
+	plan := executionPlan{
+		Version:     planFormatVersion,
+		CreatedAt:   time.Now().UTC(),
+		Fingerprint: fingerprintModels(remote),
+		Steps:       make([]planStep, 0),
+	}
+
+	setModelDependencies(local)
+	sorted, err := sortModels(local)
+	if err != nil {
+		return nil, err
+	}
+
+	batches, err := planBatches(filterModels(sorted, diff.OnlyLocal), limits)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, batch := range batches {
+		step := planStep{Action: createAction}
+		for _, entry := range batch {
+			step.ModelIds = append(step.ModelIds, entry.modelId)
+			step.Models = append(step.Models, entry.model)
+		}
+		plan.Steps = append(plan.Steps, step)
+	}
+
+	if orphans == OrphanKeep || len(diff.OnlyRemote) == 0 {
+		return &plan, nil
+	}
+
+	keptIds := make([]string, 0, len(diff.OnlyLocal)+len(diff.Identical))
+	keptIds = append(keptIds, diff.OnlyLocal...)
+	keptIds = append(keptIds, diff.Identical...)
+
+	required := requiredModelIds(filterModels(local, keptIds), remote)
+	removable := make([]string, 0)
+	for _, id := range diff.OnlyRemote {
+		if required[id] {
+			plan.Retained = append(plan.Retained, id)
+		} else {
+			removable = append(removable, id)
+		}
+	}
+
+	if len(removable) == 0 {
+		return &plan, nil
+	}
+
+	if orphans == OrphanDecommission {
+		plan.Steps = append(plan.Steps, planStep{Action: decommissionAction, ModelIds: removable})
+		return &plan, nil
+	}
+
+	setModelDependencies(remote)
+	remoteSorted, err := sortModels(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	step := planStep{Action: deleteAction}
+	for _, entry := range filterModels(reverseModels(remoteSorted), removable) {
+		step.ModelIds = append(step.ModelIds, entry.modelId)
+	}
+	plan.Steps = append(plan.Steps, step)
+
+	return &plan, nil
+}
+
+// Finds the ids of every model which the kept models depend on, directly or through other models in the instance
+func requiredModelIds(kept []*modelEntry, remote []*modelEntry) map[string]bool {
+	remoteModels := make(map[string]*modelEntry)
+	for _, entry := range remote {
+		remoteModels[entry.modelId] = entry
+	}
+
+	required := make(map[string]bool)
+	queue := make([]modelReference, 0)
+	for _, entry := range kept {
+		queue = append(queue, entry.getModelDependencies()...)
+	}
+
+	for len(queue) > 0 {
+		reference := queue[0]
+		queue = queue[1:]
+
+		if required[reference.modelId] {
+			continue
+		}
+		required[reference.modelId] = true
+
+		if entry, ok := remoteModels[reference.modelId]; ok {
+			queue = append(queue, entry.getModelDependencies()...)
+		}
+	}
+
+	return required
+}
+
+// Calculates a fingerprint of a set of models, which changes if any model is added, removed, altered or decommissioned
+func fingerprintModels(models []*modelEntry) string {
+	sorted := make([]*modelEntry, len(models))
+	copy(sorted, models)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].modelId < sorted[j].modelId })
+
+	hash := sha256.New()
+	for _, entry := range sorted {
+		content, _ := json.Marshal(entry.model)
+		hash.Write([]byte(entry.modelId))
+		hash.Write([]byte{0})
+		hash.Write(content)
+		if entry.decommissioned {
+			hash.Write([]byte("decommissioned"))
+		}
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Writes the plan to a file
+func (plan *executionPlan) save(path string) error {
+	content, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, content, 0644)
+}
+
+// Reads a plan from a file
+func loadPlan(path string) (*executionPlan, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan executionPlan
+	if err = json.Unmarshal(content, &plan); err != nil {
+		return nil, fmt.Errorf("the plan is not valid JSON: %s", err)
+	}
+
+	if plan.Version != planFormatVersion {
+		return nil, fmt.Errorf("plan format version %d is not supported, expected version %d", plan.Version, planFormatVersion)
+	}
+
+	return &plan, nil
+}
+
+// Writes a human-readable description of the plan
+func (plan *executionPlan) print() {
+	batch, batchCount := 0, 0
+	for _, step := range plan.Steps {
+		if step.Action == createAction {
+			batchCount++
+		}
+	}
+
+	for _, step := range plan.Steps {
+		switch step.Action {
+		case createAction:
+			batch++
+			fmt.Printf("Create batch %d/%d (%d model(s)):\n", batch, batchCount, len(step.ModelIds))
+			printIds("+", step.ModelIds)
+		case decommissionAction:
+			fmt.Printf("Decommission (%d model(s)):\n", len(step.ModelIds))
+			printIds("~", step.ModelIds)
+		case deleteAction:
+			fmt.Printf("Delete (%d model(s)):\n", len(step.ModelIds))
+			printIds("-", step.ModelIds)
+		}
+	}
+
+	if len(plan.Retained) > 0 {
+		fmt.Printf("Retained as other models depend on them (%d model(s)):\n", len(plan.Retained))
+		printIds("=", plan.Retained)
+	}
+
+	fmt.Printf("Plan: %d to create, %d to decommission, %d to delete\n",
+		plan.count(createAction), plan.count(decommissionAction), plan.count(deleteAction))
+}
+
+// Writes each id on its own line with the given marker
+func printIds(marker string, ids []string) {
+	for _, id := range ids {
+		fmt.Printf("  %s %s\n", marker, id)
+	}
+}
+
+// Checks if two endpoints refer to the same instance
+func sameEndpoint(a string, b string) bool {
+	return strings.EqualFold(strings.TrimRight(a, "/"), strings.TrimRight(b, "/"))
+}
@@ -0,0 +1,162 @@
+package cli
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_buildPlan(t *testing.T) {
+	local := []*modelEntry{
+		newTestEntry(t, `{"@id": "dtmi:test:child;1", "@type": "Interface", "extends": "dtmi:test:parent;1"}`),
+		newTestEntry(t, `{"@id": "dtmi:test:parent;1", "@type": "Interface", "extends": "dtmi:test:base;1"}`),
+		newTestEntry(t, `{"@id": "dtmi:test:existing;1", "@type": "Interface"}`),
+	}
+
+	remote := []*modelEntry{
+		newTestEntry(t, `{"@id": "dtmi:test:existing;1", "@type": "Interface"}`),
+		newTestEntry(t, `{"@id": "dtmi:test:base;1", "@type": "Interface"}`),
+		newTestEntry(t, `{"@id": "dtmi:test:orphan;1", "@type": "Interface"}`),
+		newTestEntry(t, `{"@id": "dtmi:test:orphanchild;1", "@type": "Interface", "extends": "dtmi:test:orphan;1"}`),
+	}
+
+	plan, err := buildPlan(local, remote, OrphanDelete, uploadBatchLimits(0))
+	if err != nil {
+		t.Fatalf("Expected a plan, but got error: %s", err)
+	}
+
+	expected := []planStep{
+		{Action: createAction, ModelIds: []string{"dtmi:test:parent;1", "dtmi:test:child;1"}},
+		{Action: deleteAction, ModelIds: []string{"dtmi:test:orphanchild;1", "dtmi:test:orphan;1"}},
+	}
+
+	if len(plan.Steps) != len(expected) {
+		t.Fatalf("Expected %d steps, but got %d", len(expected), len(plan.Steps))
+	}
+
+	for i := range expected {
+		if plan.Steps[i].Action != expected[i].Action || !reflect.DeepEqual(plan.Steps[i].ModelIds, expected[i].ModelIds) {
+			t.Errorf("Expected step %d to %s %v, but got %s %v", i, expected[i].Action, expected[i].ModelIds, plan.Steps[i].Action, plan.Steps[i].ModelIds)
+		}
+	}
+
+	if !reflect.DeepEqual(plan.Retained, []string{"dtmi:test:base;1"}) {
+		t.Errorf("Expected the base model to be retained, but got %v", plan.Retained)
+	}
+
+	if len(plan.Steps[0].Models) != 2 {
+		t.Errorf("Expected the create step to contain the model definitions")
+	}
+}
+
+func Test_buildPlan_decommission(t *testing.T) {
+	local := []*modelEntry{newTestEntry(t, `{"@id": "dtmi:test:a;1", "@type": "Interface"}`)}
+	remote := []*modelEntry{newTestEntry(t, `{"@id": "dtmi:test:b;1", "@type": "Interface"}`)}
+
+	plan, err := buildPlan(local, remote, OrphanDecommission, uploadBatchLimits(0))
+	if err != nil {
+		t.Fatalf("Expected a plan, but got error: %s", err)
+	}
+
+	if plan.count(createAction) != 1 || plan.count(decommissionAction) != 1 || plan.count(deleteAction) != 0 {
+		t.Errorf("Expected 1 model to create and 1 to decommission, but got %+v", plan.Steps)
+	}
+}
+
+func Test_buildPlan_batchLimits(t *testing.T) {
+	local := []*modelEntry{
+		newTestEntry(t, `{"@id": "dtmi:test:a;1", "@type": "Interface"}`),
+		newTestEntry(t, `{"@id": "dtmi:test:b;1", "@type": "Interface"}`),
+	}
+
+	plan, err := buildPlan(local, nil, OrphanKeep, uploadBatchLimits(0))
+	if err != nil || plan.count(createAction) != 2 || len(plan.Steps) != 1 {
+		t.Errorf("Expected the models to be created in a single step, but got %+v (%v)", plan.Steps, err)
+	}
+
+	plan, err = buildPlan(local, nil, OrphanKeep, uploadBatchLimits(60))
+	if err != nil || plan.count(createAction) != 2 || len(plan.Steps) != 2 {
+		t.Errorf("Expected each model to be created in its own step, but got %+v (%v)", plan.Steps, err)
+	}
+}
+
+func Test_buildPlan_invalidOrphans(t *testing.T) {
+	_, err := buildPlan(nil, nil, "invalid", uploadBatchLimits(0))
+	assertExpectedError(t, err, errorText("orphan action 'invalid' is not valid"))
+}
+
+func Test_fingerprintModels(t *testing.T) {
+	a := newTestEntry(t, `{"@id": "dtmi:test:a;1", "@type": "Interface"}`)
+	b := newTestEntry(t, `{"@id": "dtmi:test:b;1", "@type": "Interface"}`)
+	changedB := newTestEntry(t, `{"@id": "dtmi:test:b;1", "@type": "Interface", "displayName": "B"}`)
+
+	if fingerprintModels([]*modelEntry{a, b}) != fingerprintModels([]*modelEntry{b, a}) {
+		t.Errorf("Expected the fingerprint to be independent of the order of the models")
+	}
+
+	if fingerprintModels([]*modelEntry{a, b}) == fingerprintModels([]*modelEntry{a, changedB}) {
+		t.Errorf("Expected the fingerprint to change when a model changes")
+	}
+
+	if fingerprintModels([]*modelEntry{a, b}) == fingerprintModels([]*modelEntry{a}) {
+		t.Errorf("Expected the fingerprint to change when a model is removed")
+	}
+}
+
+func Test_executionPlan_saveAndLoad(t *testing.T) {
+	local := []*modelEntry{newTestEntry(t, `{"@id": "dtmi:test:a;1", "@type": "Interface"}`)}
+	plan, _ := buildPlan(local, nil, OrphanKeep, uploadBatchLimits(0))
+	plan.Endpoint = "https://example.api.weu.digitaltwins.azure.net"
+
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := plan.save(path); err != nil {
+		t.Fatalf("Unable to save plan: %s", err)
+	}
+
+	loaded, err := loadPlan(path)
+	if err != nil {
+		t.Fatalf("Unable to load plan: %s", err)
+	}
+
+	if loaded.Fingerprint != plan.Fingerprint || loaded.Endpoint != plan.Endpoint || len(loaded.Steps) != 1 {
+		t.Errorf("Loaded plan does not match the saved plan")
+	}
+
+	if !loaded.Steps[0].Models[0].equals(plan.Steps[0].Models[0]) {
+		t.Errorf("Expected the model definitions to be saved in the plan")
+	}
+}
+
+func TestApplyPlan_protectedEndpoint(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	remote := []*modelEntry{newTestEntry(t, `{"@id": "dtmi:test:a;1", "@type": "Interface"}`)}
+	plan, _ := buildPlan(nil, remote, OrphanDelete, uploadBatchLimits(0))
+	plan.Endpoint = server.URL
+
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	if err := plan.save(planPath); err != nil {
+		t.Fatalf("Unable to save plan: %s", err)
+	}
+
+	endpoint, _ := url.Parse(server.URL)
+	t.Setenv(configFileVariable, filepath.Join(t.TempDir(), "config.json"))
+	t.Setenv(protectedEndpointsVariable, endpoint.Hostname())
+
+	connection := Connection{Endpoint: server.URL, Authentication: &AuthenticationMethod{UseAzureCli: true}}
+	err := ApplyPlan(context.Background(), connection, planPath, ApplyOptions{Confirmed: true})
+	assertExpectedError(t, err, errorText("is a protected endpoint"))
+
+	if requests != 0 {
+		t.Errorf("Expected no requests to be made to a protected endpoint, but %d were made", requests)
+	}
+}
@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonPositions maps JSON pointers within a document to the location in the source content where they are defined,
+// so that diagnostics can refer to a line and column
+type jsonPositions struct {
+	content []byte           // The content which was indexed
+	start   int64            // The byte offset in the content of the value which was indexed
+	offsets map[string]int64 // The byte offset of each JSON pointer. Object members point at their key
+}
+
+// Indexes the position of every element in the content. If the content is not valid JSON then the elements up to the
+// point of the error are indexed
+func indexJsonPositions(content []byte) *jsonPositions {
+	return indexJsonPositionsAt(content, 0)
+}
+
+// Indexes the position of every element in the JSON value starting at the byte offset in the content, such as a single
+// line of newline delimited JSON. Offsets, and so lines and columns, remain relative to the start of the content
+func indexJsonPositionsAt(content []byte, start int64) *jsonPositions {
+	positions := &jsonPositions{
+		content: content,
+		start:   start,
+		offsets: make(map[string]int64),
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content[start:]))
+	_ = positions.index(decoder, "")
+
+	return positions
+}
+
+// Records the position of the next value in the decoder, along with any values it contains
+func (positions *jsonPositions) index(decoder *json.Decoder, pointer string) error {
+	positions.offsets[pointer] = positions.skipSeparators(positions.start + decoder.InputOffset())
+
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return nil
+	}
+
+	switch delim {
+	case '{':
+		for decoder.More() {
+			keyOffset := positions.skipSeparators(positions.start + decoder.InputOffset())
+			keyToken, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+
+			key, _ := keyToken.(string)
+			child := fmt.Sprintf("%s/%s", pointer, escapePointer(key))
+			if err := positions.index(decoder, child); err != nil {
+				return err
+			}
+			positions.offsets[child] = keyOffset
+		}
+	case '[':
+		for i := 0; decoder.More(); i++ {
+			if err := positions.index(decoder, fmt.Sprintf("%s/%d", pointer, i)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Read the closing delimiter
+	_, err = decoder.Token()
+	return err
+}
+
+// Moves the offset past any whitespace and separators so that it points at the start of the next token
+func (positions *jsonPositions) skipSeparators(offset int64) int64 {
+	for offset < int64(len(positions.content)) {
+		switch positions.content[offset] {
+		case ' ', '\t', '\r', '\n', ',', ':':
+			offset++
+		default:
+			return offset
+		}
+	}
+	return offset
+}
+
+// Gets the line and column of the JSON pointer. If the pointer has not been indexed then the location of its closest
+// indexed parent is used
+func (positions *jsonPositions) locate(pointer string) (int, int) {
+	for {
+		if offset, ok := positions.offsets[pointer]; ok {
+			return positions.lineAndColumn(offset)
+		}
+
+		index := strings.LastIndex(pointer, "/")
+		if index < 0 {
+			return 1, 1
+		}
+		pointer = pointer[:index]
+	}
+}
+
+// Converts a byte offset into a 1-based line and column
+func (positions *jsonPositions) lineAndColumn(offset int64) (int, int) {
+	if offset > int64(len(positions.content)) {
+		offset = int64(len(positions.content))
+	}
+
+	preceding := positions.content[:offset]
+	line := bytes.Count(preceding, []byte("\n")) + 1
+	lineStart := bytes.LastIndexByte(preceding, '\n') + 1
+
+	return line, utf8.RuneCount(preceding[lineStart:]) + 1
+}
+
+// Gets the offset in the content where a JSON decoding error occurred
+func errorOffset(err error) (int64, bool) {
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+
+	if errors.As(err, &syntaxError) {
+		return syntaxError.Offset, true
+	} else if errors.As(err, &typeError) {
+		return typeError.Offset, true
+	}
+	return 0, false
+}
+
+// Escapes a key so that it can be used as part of a JSON pointer
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
@@ -1,38 +1,139 @@
 package cli
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
+// DefaultConcurrency is the default maximum number of models which are deleted at the same time
+const DefaultConcurrency = 4
+
+// ClearOptions controls how models are removed by ClearModels
+type ClearOptions struct {
+	DryRun         bool        // When set, the requests which would be made are printed instead of being sent
+	Confirmed      bool        // When set, the user is not asked to confirm the models should be removed
+	AllowProtected bool        // When set, models can be removed from an instance which matches a protected endpoint
+	Concurrency    int         // The maximum number of models to delete at the same time
+	Filter         ModelFilter // Selects the models to remove, where an empty filter removes every model
+}
+
+// UploadOptions controls how models are uploaded by UploadModels
+type UploadOptions struct {
+	DryRun          bool        // When set, the requests which would be made are printed instead of being sent
+	MaxRequestBytes int         // The maximum size of the body of each upload request, with zero meaning DefaultMaxRequestBytes
+	JournalPath     string      // The location of the journal recording the progress of the upload, with an empty value meaning DefaultJournalPath
+	Resume          bool        // When set, a previous upload is continued from the first batch which the journal does not record as uploaded
+	Atomic          bool        // When set, the models created by the upload are removed again if any batch fails to upload
+	DecommissionOld bool        // When set, older versions of the models uploaded are decommissioned once the upload is complete
+	Filter          ModelFilter // Selects the models to upload, which are uploaded along with the models they depend on
+}
+
+// DecommissionOptions controls how models are selected and decommissioned by DecommissionModels
+type DecommissionOptions struct {
+	File           string // A file containing model ids or patterns to decommission, one per line
+	DryRun         bool   // When set, the requests which would be made are printed instead of being sent
+	Confirmed      bool   // When set, the user is not asked to confirm the models should be decommissioned
+	AllowProtected bool   // When set, models can be decommissioned in an instance which matches a protected endpoint
+}
+
+// DeleteOptions controls how models are selected and removed by DeleteModels
+type DeleteOptions struct {
+	File           string // A file containing model ids or patterns to delete, one per line
+	Cascade        bool   // When set, models which depend on the models being deleted are also deleted
+	DryRun         bool   // When set, the requests which would be made are printed instead of being sent
+	Confirmed      bool   // When set, the user is not asked to confirm the models should be removed
+	AllowProtected bool   // When set, models can be removed from an instance which matches a protected endpoint
+	Concurrency    int    // The maximum number of models to delete at the same time
+}
+
+// PlanOptions controls how PlanModels handles orphaned models, and how the models to create are batched
+type PlanOptions struct {
+	Orphans         string // How models which only exist in the instance are handled, one of OrphanDelete, OrphanDecommission or OrphanKeep
+	MaxRequestBytes int    // The maximum size of the body of each request used to create models, with zero meaning DefaultMaxRequestBytes
+}
+
+// ApplyOptions controls the checks made before ApplyPlan runs a plan
+type ApplyOptions struct {
+	Confirmed      bool // When set, the user is not asked to confirm a plan which removes or decommissions models
+	AllowProtected bool // When set, the plan can be applied to an instance which matches a protected endpoint
+}
+
+// GraphOptions controls which models are included in the graph written by GraphModels, and how it is written
+type GraphOptions struct {
+	Source  ModelDirectory // When set, the graph is built from the models in this directory instead of the instance
+	Format  string         // The format to write the graph in, one of GraphFormatDot, GraphFormatMermaid or GraphFormatJson
+	Root    string         // When set, only the models reachable from this model are included
+	Depth   int            // When a root is set, the maximum number of references to follow from it, with zero meaning no limit
+	Reverse bool           // When set, references are followed from each model to the models which reference it
+}
+
+// ImpactOptions controls where ImpactModels reads models from
+type ImpactOptions struct {
+	Source ModelDirectory // When set, models are read from this directory instead of the instance
+}
+
+// DownloadOptions controls how models are written by DownloadModels
+type DownloadOptions struct {
+	FileExtension string         // File extension to use for the files written, either 'json' or 'dtdl'
+	DryRun        bool           // When set, the files which would be written are printed instead of being written
+	SingleFile    string         // When set, all models are written as a JSON array to a file with this name in the output location
+	Layout        string         // The layout of the files written, either one of the Layout constants or a template, with an empty value meaning LayoutDefault
+	Clean         bool           // When set, everything in the output location is removed before the models are written
+	Filter        ModelFilter    // Selects the models to download by model id
+	Format        *FormatOptions // When set, models are written in the canonical format using these options
+}
+
 // ListModels retrieves all models which have been created against the Azure Digital Twin endpoint using the
 // authentication method provided
-func ListModels(endpoint string, method *AuthenticationMethod) error {
-	config, _ := newTwinConfiguration(endpoint, method)
+func ListModels(ctx context.Context, connection Connection) error {
+	config, _ := newTwinConfiguration(connection)
 	client := newClient(config)
 
-	models, err := client.listModels()
+	models, err := client.listModels(ctx)
 	if err != nil {
 		return fmt.Errorf("an error occured listing models in the twin: %s", err)
 	}
 
-	for _, model := range models {
-		fmt.Println(model.modelId)
-	}
+	printModelList(os.Stdout, models)
 
 	return nil
 }
 
+// Writes the id of each model on its own line, marking those which have been decommissioned
+func printModelList(out io.Writer, models []*modelEntry) {
+	for _, model := range models {
+		if model.decommissioned {
+			_, _ = fmt.Fprintf(out, "%s (decommissioned)\n", model.modelId)
+		} else {
+			_, _ = fmt.Fprintln(out, model.modelId)
+		}
+	}
+}
+
 // ClearModels will remove all models which have been created against the Azure Digital Twin endpoint using the
-// authentication method provided
-func ClearModels(endpoint string, method *AuthenticationMethod) error {
-	config, _ := newTwinConfiguration(endpoint, method)
+// authentication method provided. Unless the options say otherwise, the user is asked to confirm by typing in the host
+// name of the instance, and instances matching a protected endpoint are refused
+func ClearModels(ctx context.Context, connection Connection, options ClearOptions) error {
+	config, _ := newTwinConfiguration(connection)
 	client := newClient(config)
+	client.dryRun = options.DryRun
+
+	host := config.endpoint.Hostname()
+	if !options.DryRun {
+		if err := checkProtectedEndpoint(host, options.AllowProtected); err != nil {
+			return err
+		}
+	}
 
-	models, err := client.listModels()
+	models, err := client.listModels(ctx)
 	if err != nil {
 		return fmt.Errorf("an error occured retrieving models from the twin: %s", err)
 	}
@@ -43,32 +144,78 @@ func ClearModels(endpoint string, method *AuthenticationMethod) error {
 	}
 
 	setModelDependencies(models)
-	sorted := sortModels(models)
 
-	reversed := make([]*modelEntry, len(sorted))
+	// Only remove the selected models, which must include every model depending on them
+	selected, err := options.Filter.apply(models)
+	if err != nil {
+		return fmt.Errorf("unable to select the models to remove: %s", err)
+	}
+
+	if dependents := findDependents(models, selected); len(dependents) > 0 {
+		dependentErr := newDependentModelsError(dependents, selected)
+		dependentErr.Hint = "change the include and exclude patterns to remove them as well"
+		return dependentErr
+	}
 
-	for i, j := len(sorted)-1, 0; i >= 0; i, j = i-1, j+1 {
-		reversed[j] = sorted[i]
+	levels, err := deletionLevels(selected)
+	if err != nil {
+		return fmt.Errorf("unable to determine the order to remove models in: %w", err)
 	}
 
-	fmt.Printf("Removing %d model(s) from the digital twin instance\n", len(reversed))
+	if !options.DryRun && !options.Confirmed {
+		prompt := fmt.Sprintf("This will permanently remove all %d model(s) from %s", len(models), host)
+		if len(selected) < len(models) {
+			prompt = fmt.Sprintf("This will permanently remove %d of the %d model(s) in %s", len(selected), len(models), host)
+		}
+		if err = confirmHostname(ctx, host, prompt, os.Stdin, os.Stdout); err != nil {
+			return err
+		}
+	}
 
-	err = client.clearModels(reversed)
+	fmt.Printf("Removing %d model(s) from the digital twin instance in %d level(s)\n", len(selected), len(levels))
+
+	err = client.clearModels(ctx, levels, options.Concurrency)
 	if err != nil {
 		return fmt.Errorf("unable to clear models from the digital twin: %s", err)
 	}
 
-	fmt.Println("Successfully cleared all models from the digital twin instance")
+	if options.DryRun {
+		fmt.Println("Dry run complete, no models were removed")
+		return nil
+	}
+
+	if len(selected) < len(models) {
+		fmt.Printf("Successfully removed %d model(s) from the digital twin instance\n", len(selected))
+	} else {
+		fmt.Println("Successfully cleared all models from the digital twin instance")
+	}
 
 	return nil
 }
 
 // UploadModels will read all model files (.json and .dtdl files) in a given path recursively, and then attempt to
-// upload them to the Azure Digital Twin instance
-func UploadModels(endpoint string, method *AuthenticationMethod, source ModelDirectory) error {
-	config, _ := newTwinConfiguration(endpoint, method)
+// upload them to the Azure Digital Twin instance. Models which already exist in the instance with the same content are
+// skipped, and if any exist with different content then nothing is uploaded and a ModelConflictError is returned.
+//
+// The progress of the upload is recorded in a journal, which is removed once all models have been uploaded. If the
+// upload fails then it can be continued by setting the Resume option, which uploads the batches from the journal which
+// do not yet exist in the instance. A journal which records no uploaded batches is removed when the upload fails, and is
+// replaced by a new upload, as there is nothing to resume. Alternatively, setting the Atomic option removes every model
+// created by the upload if it fails, leaving the instance as it was before the upload started.
+//
+// Local models which are newer versions of models in the instance are reported, along with any models which still
+// reference the older versions, and the older versions are decommissioned once the upload completes if the
+// DecommissionOld option is set
+func UploadModels(ctx context.Context, connection Connection, source ModelDirectory, options UploadOptions) error {
+	config, _ := newTwinConfiguration(connection)
 	client := newClient(config)
+	client.dryRun = options.DryRun
 
+	return uploadModels(ctx, client, connection.Endpoint, source, options)
+}
+
+// Uploads the models from the source using the client, as described by UploadModels
+func uploadModels(ctx context.Context, client *client, endpoint string, source ModelDirectory, options UploadOptions) error {
 	models, err := source.getModels()
 	if err != nil {
 		return fmt.Errorf("unable to retrieve models from %s: %s", source.Path, err)
@@ -78,81 +225,705 @@ func UploadModels(endpoint string, method *AuthenticationMethod, source ModelDir
 		return fmt.Errorf("No models found to upload\n")
 	}
 
+	remote, err := client.listModels(ctx)
+	if err != nil {
+		return fmt.Errorf("an error occured listing models in the twin: %s", err)
+	}
+
+	if !options.Filter.isEmpty() {
+		models, err = selectUploadModels(models, remote, options.Filter)
+		if err != nil {
+			return fmt.Errorf("unable to select the models to upload: %s", err)
+		}
+	}
+
+	diff := diffModels(models, remote)
+	if err = diff.conflictError(); err != nil {
+		return fmt.Errorf("unable to upload models: %w", err)
+	}
+
 	setModelDependencies(models)
-	sorted := sortModels(models)
+	sorted, err := sortModels(models)
+	if err != nil {
+		return fmt.Errorf("unable to determine the order to upload models in: %w", err)
+	}
 
-	fmt.Printf("Uploading %d models to the digital twin instance\n", len(sorted))
+	// Models which already exist in the instance were upgraded when they were first uploaded
+	uploading := filterModels(models, diff.OnlyLocal)
+	upgrades := findUpgrades(uploading, remote)
+	printUpgrades(os.Stdout, upgrades, append(uploading, remote...))
 
-	err = client.uploadModels(sorted)
+	journalPath := options.JournalPath
+	if len(journalPath) == 0 {
+		journalPath = DefaultJournalPath
+	}
+
+	var journal *uploadJournal
+	var batches [][]*modelEntry
+	if options.Resume {
+		journal, err = readJournal(journalPath)
+		if err != nil {
+			return fmt.Errorf("unable to read journal %s: %s", journalPath, err)
+		}
+
+		if !sameEndpoint(journal.endpoint, endpoint) {
+			return fmt.Errorf("the journal was created for an upload to %s and cannot be resumed against %s", journal.endpoint, endpoint)
+		}
+
+		batches, err = journal.resolve(models, remote)
+		if err != nil {
+			return fmt.Errorf("unable to resume upload from %s: %s", journalPath, err)
+		}
+
+		fmt.Printf("Resuming upload from batch %d/%d\n", journal.firstUncommitted()+1, len(batches))
+	} else {
+		if !options.DryRun && journalExists(journalPath) {
+			// A journal which records no uploaded batches has nothing to resume, so it is replaced by the new upload
+			if existing, err := readJournal(journalPath); err != nil || len(existing.committed) > 0 {
+				return fmt.Errorf("a journal from an incomplete upload exists at %s, use -resume to continue the upload or remove the journal", journalPath)
+			}
+			log.Printf("Replacing journal %s as it does not record any uploaded batches", journalPath)
+		}
+
+		if len(diff.Identical) > 0 {
+			fmt.Printf("Skipping %d model(s) which already exist in the digital twin instance\n", len(diff.Identical))
+		}
+
+		toUpload := filterModels(sorted, diff.OnlyLocal)
+		if len(toUpload) == 0 {
+			fmt.Println("All models already exist in the digital twin instance")
+			return completeUpgrades(ctx, client, upgrades, remote, options.DecommissionOld)
+		}
+
+		batches, err = planBatches(toUpload, uploadBatchLimits(options.MaxRequestBytes))
+		if err != nil {
+			return fmt.Errorf("unable to upload models: %s", err)
+		}
+
+		if !options.DryRun {
+			journal, err = createJournal(journalPath, endpoint, source.Path, batches)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Only upload the batches which are not already in the instance, recording each one in the journal as it completes
+	pending := make([]int, 0, len(batches))
+	pendingBatches := make([][]*modelEntry, 0, len(batches))
+	modelCount := 0
+	for i, batch := range batches {
+		if journal == nil || !journal.committed[i] {
+			pending = append(pending, i)
+			pendingBatches = append(pendingBatches, batch)
+			modelCount += len(batch)
+		}
+	}
+
+	created := make([]*modelEntry, 0, modelCount)
+	var committed func(batch int) error
+	if journal != nil && !options.DryRun {
+		if journal.file == nil {
+			if err = journal.open(); err != nil {
+				return err
+			}
+		}
+		committed = func(batch int) error {
+			created = append(created, pendingBatches[batch]...)
+			return journal.commit(pending[batch])
+		}
+	}
+
+	if len(pendingBatches) == 0 {
+		fmt.Println("All batches recorded in the journal already exist in the digital twin instance")
+	} else {
+		fmt.Printf("Uploading %d models to the digital twin instance\n", modelCount)
+	}
+
+	err = client.uploadBatches(ctx, pendingBatches, committed)
 	if err != nil {
-		return fmt.Errorf("unable to upload models: %s", err)
+		if committed == nil {
+			return fmt.Errorf("unable to upload models: %s", err)
+		}
+
+		if options.Atomic {
+			created = append(created, findUnrecordedModels(ctx, client, pendingBatches, created)...)
+			if rollbackErr := rollbackModels(ctx, client, created); rollbackErr != nil {
+				_ = journal.close()
+				return fmt.Errorf("unable to upload models: %s\nunable to remove the %d model(s) created by the upload: %s\nprogress has been recorded in %s", err, len(created), rollbackErr, journalPath)
+			}
+
+			if removeErr := journal.remove(); removeErr != nil {
+				log.Printf("Unable to remove journal %s: %s", journalPath, removeErr)
+			}
+			return fmt.Errorf("unable to upload models: %s\nthe %d model(s) created by the upload have been removed", err, len(created))
+		}
+
+		if len(journal.committed) == 0 {
+			// Nothing was uploaded, so there is nothing to resume and the upload can simply be run again
+			if removeErr := journal.remove(); removeErr != nil {
+				log.Printf("Unable to remove journal %s: %s", journalPath, removeErr)
+			}
+			return fmt.Errorf("unable to upload models: %s", err)
+		}
+
+		_ = journal.close()
+		return fmt.Errorf("unable to upload models: %s\nprogress has been recorded in %s, run upload with -resume to continue", err, journalPath)
 	}
 
-	fmt.Printf("Successfully uploaded models from %s\n", source.Path)
+	if committed != nil {
+		if err = journal.remove(); err != nil {
+			log.Printf("Unable to remove journal %s: %s", journalPath, err)
+		}
+	}
+
+	if !options.DryRun {
+		fmt.Printf("Successfully uploaded models from %s\n", source.Path)
+	}
+
+	err = completeUpgrades(ctx, client, upgrades, remote, options.DecommissionOld)
+	if err != nil {
+		return err
+	}
+
+	if options.DryRun {
+		fmt.Println("Dry run complete, no models were uploaded")
+	}
 
 	return nil
 }
 
-// DownloadModels reads all models from the Digital Twin instance into the output location using the fileExtension
-// specified.
+// Selects the models to upload using the filter, adding any models from the source which the selected models need
+func selectUploadModels(models []*modelEntry, remote []*modelEntry, filter ModelFilter) ([]*modelEntry, error) {
+	selected, err := filter.apply(models)
+	if err != nil {
+		return nil, err
+	}
+
+	selected, added, err := includeDependencies(models, selected, remote)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Selected %d of the %d model(s) in the source\n", len(selected)-len(added), len(models))
+	if len(added) > 0 {
+		fmt.Printf("Including %d model(s) which the selected models depend on:\n", len(added))
+		for _, entry := range added {
+			fmt.Printf("  %s\n", entry.modelId)
+		}
+	}
+
+	return selected, nil
+}
+
+// Writes out the models being upgraded to a newer version, and warns about any models which reference the older
+// versions
+func printUpgrades(out io.Writer, upgrades []modelUpgrade, models []*modelEntry) {
+	for _, upgrade := range upgrades {
+		_, _ = fmt.Fprintf(out, "Upgrading %s to %s\n", strings.Join(upgrade.previous, ", "), upgrade.modelId)
+	}
+
+	stale := findStaleReferences(models, upgrades)
+	if len(stale) == 0 {
+		return
+	}
+
+	_, _ = fmt.Fprintf(out, "Warning: %d reference(s) to older versions of upgraded models remain, the referencing models need a new version to use the upgrades:\n", len(stale))
+	for _, reference := range stale {
+		_, _ = fmt.Fprintf(out, "  %s references %s (%s), which is replaced by %s\n", reference.modelId, reference.reference, reference.kind, reference.replacedBy)
+	}
+}
+
+// Decommissions the older versions of upgraded models if requested, skipping any which are already decommissioned
+func completeUpgrades(ctx context.Context, client *client, upgrades []modelUpgrade, remote []*modelEntry, decommissionOld bool) error {
+	if !decommissionOld || len(upgrades) == 0 {
+		return nil
+	}
+
+	decommissioned := make(map[string]bool)
+	for _, entry := range remote {
+		decommissioned[entry.modelId] = entry.decommissioned
+	}
+
+	modelIds := make([]string, 0)
+	for _, upgrade := range upgrades {
+		for _, id := range upgrade.previous {
+			if !decommissioned[id] {
+				modelIds = append(modelIds, id)
+			}
+		}
+	}
+
+	if len(modelIds) == 0 {
+		return nil
+	}
+
+	fmt.Printf("Decommissioning %d older model version(s)\n", len(modelIds))
+
+	err := client.decommissionModels(ctx, modelIds)
+	if err != nil {
+		return fmt.Errorf("the models were uploaded, but the older versions could not be decommissioned: %s", err)
+	}
+
+	return nil
+}
+
+// Finds the models in the batches which exist in the Azure Digital Twin instance but were not recorded as uploaded, as
+// a batch which fails can still have created some of its models before the failure
+func findUnrecordedModels(ctx context.Context, client *client, batches [][]*modelEntry, recorded []*modelEntry) []*modelEntry {
+	if ctx.Err() != nil {
+		ctx = context.Background()
+	}
+
+	remote, err := client.listModels(ctx)
+	if err != nil {
+		log.Printf("Unable to check for models created by the failed batch: %s", err)
+		return nil
+	}
+
+	exists := make(map[string]bool, len(remote))
+	for _, entry := range remote {
+		exists[entry.modelId] = true
+	}
+	for _, entry := range recorded {
+		exists[entry.modelId] = false
+	}
+
+	unrecorded := make([]*modelEntry, 0)
+	for _, batch := range batches {
+		for _, entry := range batch {
+			if exists[entry.modelId] {
+				unrecorded = append(unrecorded, entry)
+			}
+		}
+	}
+	return unrecorded
+}
+
+// Removes the models created by a failed upload from the Azure Digital Twin instance, with the models which depend on
+// others removed first. If the upload was stopped because the context was cancelled then the models are still removed
+func rollbackModels(ctx context.Context, client *client, created []*modelEntry) error {
+	if len(created) == 0 {
+		return nil
+	}
+
+	levels, err := deletionLevels(created)
+	if err != nil {
+		return err
+	}
+
+	if ctx.Err() != nil {
+		ctx = context.Background()
+	}
+
+	fmt.Printf("Removing %d model(s) created by the failed upload\n", len(created))
+	return client.clearModels(ctx, levels, DefaultConcurrency)
+}
+
+// DecommissionModels marks the models in the Azure Digital Twin instance which match the patterns as decommissioned,
+// which stops new twins from being created from them while leaving existing twins untouched. Patterns are model ids or
+// globs (e.g. "dtmi:com:example:*;1"), and more can be read from the file in the options. The API does not allow a
+// model to be recommissioned, and so the user is asked to confirm by typing in the host name of the instance unless
+// the options say otherwise
+func DecommissionModels(ctx context.Context, connection Connection, patterns []string, options DecommissionOptions) error {
+	config, _ := newTwinConfiguration(connection)
+	client := newClient(config)
+	client.dryRun = options.DryRun
+
+	if len(options.File) > 0 {
+		filePatterns, err := readModelPatterns(options.File)
+		if err != nil {
+			return fmt.Errorf("unable to read model ids from %s: %s", options.File, err)
+		}
+		patterns = append(patterns, filePatterns...)
+	}
+
+	if len(patterns) == 0 {
+		return fmt.Errorf("no models were given to decommission")
+	}
+
+	host := config.endpoint.Hostname()
+	if !options.DryRun {
+		if err := checkProtectedEndpoint(host, options.AllowProtected); err != nil {
+			return err
+		}
+	}
+
+	models, err := client.listModels(ctx)
+	if err != nil {
+		return fmt.Errorf("an error occured listing models in the twin: %s", err)
+	}
+
+	matched, err := matchModelIds(patterns, models)
+	if err != nil {
+		return fmt.Errorf("unable to select models to decommission: %s", err)
+	}
+
+	decommissioned := make(map[string]bool)
+	for _, entry := range models {
+		decommissioned[entry.modelId] = entry.decommissioned
+	}
+
+	modelIds := make([]string, 0, len(matched))
+	for _, id := range matched {
+		if decommissioned[id] {
+			fmt.Printf("Skipping %s which is already decommissioned\n", id)
+		} else {
+			modelIds = append(modelIds, id)
+		}
+	}
+
+	if len(modelIds) == 0 {
+		fmt.Println("No models to decommission")
+		return nil
+	}
+
+	if !options.DryRun && !options.Confirmed {
+		printIds("~", modelIds)
+		prompt := fmt.Sprintf("This will decommission %d model(s) in %s, which cannot be undone", len(modelIds), host)
+		if err = confirmHostname(ctx, host, prompt, os.Stdin, os.Stdout); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Decommissioning %d model(s) in the digital twin instance\n", len(modelIds))
+
+	err = client.decommissionModels(ctx, modelIds)
+	if err != nil {
+		return fmt.Errorf("unable to decommission models: %s", err)
+	}
+
+	if options.DryRun {
+		fmt.Println("Dry run complete, no models were decommissioned")
+		return nil
+	}
+
+	fmt.Printf("Successfully decommissioned %d model(s)\n", len(modelIds))
+
+	return nil
+}
+
+// DeleteModels removes the models in the Azure Digital Twin instance which match the patterns, which are model ids or
+// globs (e.g. "dtmi:com:example:*;1"), along with any read from the file in the options. A model cannot be removed
+// while other models depend on it, and so if other models depend on those selected then they are also removed when the
+// Cascade option is set, otherwise nothing is removed and the dependents are listed in the error
+func DeleteModels(ctx context.Context, connection Connection, patterns []string, options DeleteOptions) error {
+	config, _ := newTwinConfiguration(connection)
+	client := newClient(config)
+	client.dryRun = options.DryRun
+
+	return deleteModels(ctx, client, patterns, options)
+}
+
+// Removes the models matching the patterns using the client, as described by DeleteModels
+func deleteModels(ctx context.Context, client *client, patterns []string, options DeleteOptions) error {
+	if len(options.File) > 0 {
+		filePatterns, err := readModelPatterns(options.File)
+		if err != nil {
+			return fmt.Errorf("unable to read model ids from %s: %s", options.File, err)
+		}
+		patterns = append(patterns, filePatterns...)
+	}
+
+	if len(patterns) == 0 {
+		return fmt.Errorf("no models were given to delete")
+	}
+
+	host := client.configuration.endpoint.Hostname()
+	if !options.DryRun {
+		if err := checkProtectedEndpoint(host, options.AllowProtected); err != nil {
+			return err
+		}
+	}
+
+	models, err := client.listModels(ctx)
+	if err != nil {
+		return fmt.Errorf("an error occured listing models in the twin: %s", err)
+	}
+
+	matched, err := matchModelIds(patterns, models)
+	if err != nil {
+		return fmt.Errorf("unable to select models to delete: %s", err)
+	}
+
+	setModelDependencies(models)
+	selected := filterModels(models, matched)
+	dependents := findDependents(models, selected)
+
+	toDelete := make([]*modelEntry, 0, len(selected)+len(dependents))
+	toDelete = append(toDelete, selected...)
+	toDelete = append(toDelete, dependents...)
+
+	if len(dependents) > 0 && !options.Cascade {
+		return newDependentModelsError(dependents, toDelete)
+	}
+
+	levels, err := deletionLevels(toDelete)
+	if err != nil {
+		return fmt.Errorf("unable to determine the order to remove models in: %w", err)
+	}
+
+	if len(dependents) > 0 {
+		fmt.Printf("Also removing %d model(s) which depend on the selected models\n", len(dependents))
+	}
+
+	if !options.DryRun && !options.Confirmed {
+		printIds("-", matched)
+		for _, entry := range dependents {
+			fmt.Printf("  - %s (dependent)\n", entry.modelId)
+		}
+		prompt := fmt.Sprintf("This will permanently remove %d model(s) from %s", len(toDelete), host)
+		if err = confirmHostname(ctx, host, prompt, os.Stdin, os.Stdout); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Removing %d model(s) from the digital twin instance in %d level(s)\n", len(toDelete), len(levels))
+
+	err = client.clearModels(ctx, levels, options.Concurrency)
+	if err != nil {
+		return fmt.Errorf("unable to delete models from the digital twin: %s", err)
+	}
+
+	if options.DryRun {
+		fmt.Println("Dry run complete, no models were removed")
+		return nil
+	}
+
+	fmt.Printf("Successfully removed %d model(s) from the digital twin instance\n", len(toDelete))
+
+	return nil
+}
+
+// GraphModels writes the graph of references between models, showing which models each model extends, embeds as a
+// component, targets with a relationship, or uses a schema from. The models are read from the source directory in the
+// options if it is set, otherwise they are read from the Azure Digital Twin instance
+func GraphModels(ctx context.Context, connection Connection, options GraphOptions) error {
+	var models []*modelEntry
+	var err error
+
+	if len(options.Source.Path) > 0 {
+		models, err = options.Source.getModels()
+		if err != nil {
+			return fmt.Errorf("unable to retrieve models from %s: %s", options.Source.Path, err)
+		}
+	} else {
+		config, _ := newTwinConfiguration(connection)
+		client := newClient(config)
+
+		models, err = client.listModels(ctx)
+		if err != nil {
+			return fmt.Errorf("an error occured listing models in the twin: %s", err)
+		}
+	}
+
+	graph := buildModelGraph(models)
+	if options.Reverse {
+		graph = graph.reverse()
+	}
+
+	if len(options.Root) > 0 {
+		graph, err = graph.subgraph(options.Root, options.Depth)
+		if err != nil {
+			return fmt.Errorf("unable to create graph: %s", err)
+		}
+	}
+
+	err = graph.write(os.Stdout, options.Format)
+	if err != nil {
+		return fmt.Errorf("unable to write graph: %s", err)
+	}
+
+	return nil
+}
+
+// ImpactModels lists every model which would be affected by a change to the given model, because it extends, embeds
+// as a component, uses a schema from, or targets with a relationship the model either directly or through other
+// affected models. When connected to an instance the twins using each of the affected models are also counted, unless
+// the credentials are not allowed to query twins
+func ImpactModels(ctx context.Context, connection Connection, modelId string, options ImpactOptions) error {
+	var client *client
+	if len(connection.Endpoint) > 0 {
+		config, _ := newTwinConfiguration(connection)
+		client = newClient(config)
+	}
+
+	var models []*modelEntry
+	var err error
+
+	if len(options.Source.Path) > 0 {
+		models, err = options.Source.getModels()
+		if err != nil {
+			return fmt.Errorf("unable to retrieve models from %s: %s", options.Source.Path, err)
+		}
+	} else {
+		models, err = client.listModels(ctx)
+		if err != nil {
+			return fmt.Errorf("an error occured listing models in the twin: %s", err)
+		}
+	}
+
+	impacted, err := findImpactedModels(buildModelGraph(models), modelId)
+	if err != nil {
+		return fmt.Errorf("unable to analyse the impact of changing %s: %s", modelId, err)
+	}
+
+	var twinCounts map[string]int
+	if client != nil {
+		twinCounts, err = countImpactedTwins(ctx, client, modelId, impacted)
+		if errors.Is(err, errTwinQueryForbidden) {
+			fmt.Printf("Twins have not been counted as %s\n", err)
+		} else if err != nil {
+			return fmt.Errorf("unable to count the twins affected by changing %s: %s", modelId, err)
+		}
+	}
+
+	printImpact(modelId, impacted, twinCounts)
+
+	return nil
+}
+
+// Counts the twins using the changed model and each of the models affected by the change
+func countImpactedTwins(ctx context.Context, client *client, modelId string, impacted []impactedModel) (map[string]int, error) {
+	token, err := client.configuration.getBearerToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	modelIds := []string{modelId}
+	for _, model := range impacted {
+		modelIds = append(modelIds, model.entry.modelId)
+	}
+
+	counts := make(map[string]int)
+	for _, id := range modelIds {
+		count, err := client.countTwins(ctx, id, token)
+		if err != nil {
+			return nil, err
+		}
+		counts[id] = count
+	}
+
+	return counts, nil
+}
+
+// DownloadModels reads all models from the Digital Twin instance into the output location using the file extension
+// specified in the options.
 //
-// The download structure will be based on the model name structure broken apart by the colon and the
-// semicolon, and so a model id of "dtmi:rec33:architectural:building;1" will become the following path
-// "dtmi/rec33/architectural/building_1.dtdl" (assuming a file extension of 'dtdl')
-func DownloadModels(endpoint string, method *AuthenticationMethod, output ModelDirectory, fileExtension string) error {
+// The download structure is decided by the layout in the options, which is either one of the named layouts or a
+// template. The default layout is based on the model name structure broken apart by the colon and the semicolon, and
+// so a model id of "dtmi:rec33:architectural:building;1" will become the following path
+// "dtmi/rec33/architectural/building_1.dtdl" (assuming a file extension of 'dtdl'). Models which the layout writes to
+// the same file are written to it as a JSON array, and if the options name a single file then all models are written
+// to that file.
+//
+// Files are written into the output location alongside any files already there, unless the Clean option is set in
+// which case the output location is emptied first
+func DownloadModels(ctx context.Context, connection Connection, output ModelDirectory, options DownloadOptions) error {
 	// Validate the file extension
-	fileExtensionLower := strings.TrimPrefix(strings.ToLower(fileExtension), ".")
+	fileExtensionLower := strings.TrimPrefix(strings.ToLower(options.FileExtension), ".")
 	if fileExtensionLower != "json" && fileExtensionLower != "dtdl" {
 		return fmt.Errorf("file extension '%s' is not valid, only 'json' or 'dtdl' should be provided", fileExtensionLower)
 	}
 
-	config, _ := newTwinConfiguration(endpoint, method)
+	if !output.isDirectory() {
+		return fmt.Errorf("models can only be downloaded to a directory, not to %s", output.Path)
+	}
+
+	layoutTemplate := options.Layout
+	if len(options.SingleFile) > 0 {
+		layoutTemplate = options.SingleFile
+	}
+
+	layout, err := newDownloadLayout(layoutTemplate, fileExtensionLower)
+	if err != nil {
+		return err
+	}
+
+	var formatter *modelFormatter
+	if options.Format != nil {
+		formatter, err = newModelFormatter(*options.Format)
+		if err != nil {
+			return err
+		}
+	}
+
+	config, _ := newTwinConfiguration(connection)
 	client := newClient(config)
 
-	models, err := client.listModels()
+	models, err := client.listModels(ctx)
 	if err != nil {
 		return fmt.Errorf("an error occured listing models in the twin: %s", err)
 	}
 
+	models, err = options.Filter.apply(models)
+	if err != nil {
+		return fmt.Errorf("unable to select the models to download: %s", err)
+	}
+
 	// If there's no models to download then exit here
 	if len(models) == 0 {
 		return nil
 	}
 
-	// Clear anything in the output path
-	err = os.RemoveAll(output.Path)
+	files, err := layout.files(models)
 	if err != nil {
-		return fmt.Errorf("unable to clear output directory %s. %s", output, err)
+		return fmt.Errorf("unable to download models: %s", err)
 	}
 
-	err = os.Mkdir(output.Path, os.ModePerm)
-	if err != nil {
-		return fmt.Errorf("unable to create output directory %s. %s", output, err)
-	}
+	// Clear anything in the output path if asked to
+	if options.Clean {
+		if options.DryRun {
+			fmt.Printf("[dry-run] remove directory %s\n", output.Path)
+			fmt.Printf("[dry-run] create directory %s\n", output.Path)
+		} else {
+			err = os.RemoveAll(output.Path)
+			if err != nil {
+				return fmt.Errorf("unable to clear output directory %s. %s", output, err)
+			}
 
-	// Process each model
-	for _, model := range models {
-		nameParts := strings.Split(model.modelId, ":")
-		dirParts := nameParts[:len(nameParts)-1]
+			err = os.Mkdir(output.Path, os.ModePerm)
+			if err != nil {
+				return fmt.Errorf("unable to create output directory %s. %s", output, err)
+			}
+		}
+	}
 
-		// Lower case the path
-		for i := range dirParts {
-			dirParts[i] = strings.ToLower(dirParts[i])
+	// Process each file
+	for i, file := range files {
+		if ctx.Err() != nil {
+			return cancellationError(ctx, fmt.Sprintf("writing %d/%d files", i, len(files)))
 		}
 
-		// Generate the file name, output directory, and full output path
-		filename := fmt.Sprintf("%s.%s", strings.ReplaceAll(nameParts[len(nameParts)-1], ";", "_"), fileExtensionLower)
-		outputDir := filepath.Join(output.Path, filepath.Join(dirParts...))
-		outputFilePath := filepath.Join(outputDir, filename)
+		outputFilePath := filepath.Join(output.Path, filepath.FromSlash(file.path))
+		if len(file.models) > 1 || len(options.SingleFile) > 0 {
+			err = writeModelArray(file.models, outputFilePath, formatter, options.DryRun)
+			if err != nil {
+				return err
+			}
+			continue
+		}
 
-		err = os.MkdirAll(outputDir, os.ModePerm)
+		model := file.models[0]
+		var modelContent []byte
+		if formatter != nil {
+			modelContent, err = formatter.format(model.model)
+		} else {
+			modelContent, err = model.model.ToJson()
+		}
 		if err != nil {
-			return fmt.Errorf("unable to create directory %s: %s", outputDir, err)
+			return fmt.Errorf("unable to parse content of model %s. %s", model.modelId, err)
+		}
+
+		if options.DryRun {
+			fmt.Printf("[dry-run] write %s (%s, %d bytes)\n", outputFilePath, model.modelId, len(modelContent))
+			continue
 		}
 
-		modelContent, err := model.model.ToJson()
+		outputDir := filepath.Dir(outputFilePath)
+		err = os.MkdirAll(outputDir, os.ModePerm)
 		if err != nil {
-			return fmt.Errorf("unable to parse content of model %s. %s", model.modelId, err)
+			return fmt.Errorf("unable to create directory %s: %s", outputDir, err)
 		}
 
 		log.Printf("Writing model %s to %s", model.modelId, outputFilePath)
@@ -162,5 +933,312 @@ func DownloadModels(endpoint string, method *AuthenticationMethod, output ModelD
 		}
 	}
 
+	if options.DryRun {
+		fmt.Println("Dry run complete, no files were written")
+	}
+
+	return nil
+}
+
+// Writes the models to a single file as a JSON array, which is the format accepted by the Azure Digital Twin API. The
+// models are written in the canonical format when a formatter is given
+func writeModelArray(models []*modelEntry, outputFilePath string, formatter *modelFormatter, dryRun bool) error {
+	var content []byte
+	var err error
+	if formatter != nil {
+		values := make([]interface{}, len(models))
+		for i := range models {
+			values[i] = models[i].model
+		}
+		content, err = formatter.format(values)
+	} else {
+		content, err = json.MarshalIndent(batchToJsonArray(models), "", "  ")
+	}
+	if err != nil {
+		return fmt.Errorf("unable to convert models to JSON. %s", err)
+	}
+
+	if dryRun {
+		fmt.Printf("[dry-run] write %s (%d models, %d bytes)\n", outputFilePath, len(models), len(content))
+		return nil
+	}
+
+	outputDir := filepath.Dir(outputFilePath)
+	err = os.MkdirAll(outputDir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("unable to create directory %s: %s", outputDir, err)
+	}
+
+	log.Printf("Writing %d models to %s", len(models), outputFilePath)
+	err = os.WriteFile(outputFilePath, content, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("unable to write models to %s. %s", outputFilePath, err)
+	}
+
+	return nil
+}
+
+// FormatModels rewrites the model files in the source with the keys of each object in the canonical DTDL order, using
+// the indentation and line endings of the options. Models read from stdin are written to stdout, and models in an
+// archive can only be checked. In check mode the files which are not formatted are listed instead of being written,
+// and ErrNotFormatted is returned if there are any
+func FormatModels(source ModelDirectory, options FormatOptions) error {
+	formatter, err := newModelFormatter(options)
+	if err != nil {
+		return err
+	}
+
+	toStdout := source.Path == StdinSource && !options.Check
+	if !source.isDirectory() && !toStdout && !options.Check {
+		return fmt.Errorf("the models in %s can only be checked, as it is not a directory", source.Path)
+	}
+
+	files, err := source.readFiles()
+	if err != nil {
+		return fmt.Errorf("unable to read models from %s: %s", source.Path, err)
+	}
+
+	unformatted, failed := 0, 0
+	for _, file := range files {
+		if file.err != nil {
+			fmt.Printf("%s: unable to read file: %s\n", file.path, file.err)
+			failed++
+			continue
+		}
+
+		formatted, err := formatter.formatFile(file.content)
+		if err != nil {
+			fmt.Printf("%s: %s\n", file.path, err)
+			failed++
+			continue
+		}
+
+		if toStdout {
+			_, _ = os.Stdout.Write(formatted)
+			continue
+		} else if bytes.Equal(formatted, file.content) {
+			continue
+		}
+
+		unformatted++
+		if options.Check {
+			fmt.Println(file.path)
+			continue
+		}
+
+		fmt.Printf("Formatting %s\n", file.path)
+		err = os.WriteFile(file.path, formatted, os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("unable to write %s: %s", file.path, err)
+		}
+	}
+
+	if toStdout {
+		if failed > 0 {
+			return fmt.Errorf("the models could not be formatted")
+		}
+		return nil
+	}
+
+	if options.Check {
+		fmt.Printf("Checked %d file(s), %d are not formatted\n", len(files), unformatted)
+	} else {
+		fmt.Printf("Formatted %d of %d file(s)\n", unformatted, len(files))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d file(s) could not be formatted", failed)
+	} else if options.Check && unformatted > 0 {
+		return ErrNotFormatted
+	}
+
+	return nil
+}
+
+// ValidateModels reads all model files (.json and .dtdl files) in a given path recursively and validates them against
+// the DTDL v2 and v3 rules without connecting to an Azure Digital Twin instance. Each problem found is printed with the
+// file, line and column it was found at, and an error is returned if any of the problems would cause the models to be
+// rejected by the service
+func ValidateModels(source ModelDirectory) error {
+	files, err := source.readFiles()
+	if err != nil {
+		return fmt.Errorf("unable to read models from %s: %s", source.Path, err)
+	}
+
+	diagnostics := validateModelFiles(files)
+
+	errorCount, warningCount := 0, 0
+	for _, d := range diagnostics {
+		fmt.Println(d)
+		if d.severity == errorSeverity {
+			errorCount++
+		} else {
+			warningCount++
+		}
+	}
+
+	fmt.Printf("Validated %d file(s) with %d error(s) and %d warning(s)\n", len(files), errorCount, warningCount)
+
+	if errorCount > 0 {
+		return fmt.Errorf("validation of the models in %s failed", source.Path)
+	}
+
+	return nil
+}
+
+// DiffModels compares the models in the source directory with those in the Azure Digital Twin instance, and writes out
+// which models only exist locally, which only exist in the instance, which are identical, and which have the same id
+// but different content. The output format is either "text" or "json". If the models are not the same then
+// ErrModelsDiffer is returned
+func DiffModels(ctx context.Context, connection Connection, source ModelDirectory, format string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("output format '%s' is not valid, only 'text' or 'json' should be provided", format)
+	}
+
+	config, _ := newTwinConfiguration(connection)
+	client := newClient(config)
+
+	local, err := source.getModels()
+	if err != nil {
+		return fmt.Errorf("unable to retrieve models from %s: %s", source.Path, err)
+	}
+
+	remote, err := client.listModels(ctx)
+	if err != nil {
+		return fmt.Errorf("an error occured listing models in the twin: %s", err)
+	}
+
+	diff := diffModels(local, remote)
+
+	if format == "json" {
+		err = diff.printJson()
+		if err != nil {
+			return fmt.Errorf("unable to write differences: %s", err)
+		}
+	} else {
+		diff.printText()
+	}
+
+	if diff.hasDifferences() {
+		return ErrModelsDiffer
+	}
+
+	return nil
+}
+
+// PlanModels works out the operations needed to make the Azure Digital Twin instance match the models in the source
+// directory, and writes them to the plan file so that they can be reviewed before being run with ApplyPlan. Models
+// are created in the same batches an upload with the same request size would use
+func PlanModels(ctx context.Context, connection Connection, source ModelDirectory, planPath string, options PlanOptions) error {
+	config, _ := newTwinConfiguration(connection)
+	client := newClient(config)
+
+	local, err := source.getModels()
+	if err != nil {
+		return fmt.Errorf("unable to retrieve models from %s: %s", source.Path, err)
+	}
+
+	remote, err := client.listModels(ctx)
+	if err != nil {
+		return fmt.Errorf("an error occured listing models in the twin: %s", err)
+	}
+
+	plan, err := buildPlan(local, remote, options.Orphans, uploadBatchLimits(options.MaxRequestBytes))
+	if err != nil {
+		return fmt.Errorf("unable to create plan: %w", err)
+	}
+	plan.Endpoint = connection.Endpoint
+	plan.Source = source.Path
+
+	plan.print()
+
+	err = plan.save(planPath)
+	if err != nil {
+		return fmt.Errorf("unable to write plan to %s: %s", planPath, err)
+	}
+
+	fmt.Printf("Plan written to %s\n", planPath)
+
+	return nil
+}
+
+// ApplyPlan runs the operations in a plan created by PlanModels against the Azure Digital Twin instance. The plan is
+// not run if it was created for a different instance, or if the models in the instance have changed since the plan
+// was created, or if the instance is a protected endpoint and protected endpoints are not allowed. The user is asked to
+// confirm a plan which removes or decommissions models unless the options say it has already been confirmed
+func ApplyPlan(ctx context.Context, connection Connection, planPath string, options ApplyOptions) error {
+	plan, err := loadPlan(planPath)
+	if err != nil {
+		return fmt.Errorf("unable to read plan from %s: %s", planPath, err)
+	}
+
+	if !sameEndpoint(plan.Endpoint, connection.Endpoint) {
+		return fmt.Errorf("the plan was created for %s and cannot be applied to %s", plan.Endpoint, connection.Endpoint)
+	}
+
+	config, _ := newTwinConfiguration(connection)
+	client := newClient(config)
+
+	host := config.endpoint.Hostname()
+	if err := checkProtectedEndpoint(host, options.AllowProtected); err != nil {
+		return err
+	}
+
+	remote, err := client.listModels(ctx)
+	if err != nil {
+		return fmt.Errorf("an error occured listing models in the twin: %s", err)
+	}
+
+	if fingerprintModels(remote) != plan.Fingerprint {
+		return fmt.Errorf("the models in the digital twin instance have changed since the plan was created, a new plan must be created")
+	}
+
+	removing := plan.count(decommissionAction) + plan.count(deleteAction)
+	if removing > 0 && !options.Confirmed {
+		prompt := fmt.Sprintf("This plan will decommission %d and permanently remove %d model(s) in %s", plan.count(decommissionAction), plan.count(deleteAction), host)
+		if err = confirmHostname(ctx, host, prompt, os.Stdin, os.Stdout); err != nil {
+			return err
+		}
+	}
+
+	setModelDependencies(remote)
+
+	for i, step := range plan.Steps {
+		if ctx.Err() != nil {
+			return cancellationError(ctx, fmt.Sprintf("applying %d/%d steps of the plan", i, len(plan.Steps)))
+		}
+
+		fmt.Printf("Step %d/%d: %s %d model(s)\n", i+1, len(plan.Steps), step.Action, len(step.ModelIds))
+
+		switch step.Action {
+		case createAction:
+			batch := make([]*modelEntry, 0, len(step.Models))
+			for _, model := range step.Models {
+				entry, err := newModelEntry(model)
+				if err != nil {
+					return fmt.Errorf("the plan contains an invalid model: %s", err)
+				}
+				batch = append(batch, entry)
+			}
+			err = client.uploadBatches(ctx, [][]*modelEntry{batch}, nil)
+		case decommissionAction:
+			err = client.decommissionModels(ctx, step.ModelIds)
+		case deleteAction:
+			var levels [][]*modelEntry
+			levels, err = deletionLevels(filterModels(remote, step.ModelIds))
+			if err == nil {
+				err = client.clearModels(ctx, levels, 1)
+			}
+		default:
+			err = fmt.Errorf("unknown action '%s'", step.Action)
+		}
+
+		if err != nil {
+			return fmt.Errorf("unable to apply step %d of the plan: %s", i+1, err)
+		}
+	}
+
+	fmt.Println("Successfully applied the plan to the digital twin instance")
+
 	return nil
 }
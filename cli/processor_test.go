@@ -0,0 +1,169 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Creates a source of four models, each extending the one before it, where the first model already exists in the
+// instance. Each of the other models is uploaded in its own batch
+func newRollbackTest(t *testing.T, instance *fakeInstance) (*client, ModelDirectory) {
+	existing := `{"@id": "dtmi:test:a;1", "@type": "Interface"}`
+	source := newTestSource(t,
+		existing,
+		`{"@id": "dtmi:test:b;1", "@type": "Interface", "extends": "dtmi:test:a;1"}`,
+		`{"@id": "dtmi:test:c;1", "@type": "Interface", "extends": "dtmi:test:b;1"}`,
+		`{"@id": "dtmi:test:d;1", "@type": "Interface", "extends": "dtmi:test:c;1"}`,
+	)
+
+	return newFakeInstanceClient(t, instance, newTestEntry(t, existing)), source
+}
+
+func Test_uploadModels_atomicRollback(t *testing.T) {
+	instance := &fakeInstance{failUpload: 3}
+	client, source := newRollbackTest(t, instance)
+	journalPath := filepath.Join(t.TempDir(), "upload.jsonl")
+
+	options := UploadOptions{JournalPath: journalPath, MaxRequestBytes: 100, Atomic: true}
+	err := uploadModels(context.Background(), client, "https://example.api.weu.digitaltwins.azure.net", source, options)
+	if err == nil || !strings.Contains(err.Error(), "DTDLParserError") || !strings.Contains(err.Error(), "the 2 model(s) created by the upload have been removed") {
+		t.Errorf("Expected the upload error and the rollback to be reported, but got: %v", err)
+	}
+
+	if deleted := strings.Join(instance.deleted, ","); deleted != "dtmi:test:c;1,dtmi:test:b;1" {
+		t.Errorf("Expected the created models to be removed in reverse dependency order, but %s were removed", deleted)
+	}
+
+	if ids := instance.modelIds(); ids != "dtmi:test:a;1" {
+		t.Errorf("Expected the model which already existed to be left in the instance, but the instance has %s", ids)
+	}
+
+	if journalExists(journalPath) {
+		t.Errorf("Expected the journal to be removed once the upload was rolled back")
+	}
+}
+
+func Test_uploadModels_atomicRollbackPartialBatch(t *testing.T) {
+	instance := &fakeInstance{failUpload: 1, partial: 1}
+	client, source := newRollbackTest(t, instance)
+	journalPath := filepath.Join(t.TempDir(), "upload.jsonl")
+
+	options := UploadOptions{JournalPath: journalPath, MaxRequestBytes: 100, Atomic: true}
+	err := uploadModels(context.Background(), client, "https://example.api.weu.digitaltwins.azure.net", source, options)
+	if err == nil || !strings.Contains(err.Error(), "the 1 model(s) created by the upload have been removed") {
+		t.Errorf("Expected the model created by the failed batch to be removed, but got: %v", err)
+	}
+
+	if deleted := strings.Join(instance.deleted, ","); deleted != "dtmi:test:b;1" {
+		t.Errorf("Expected the model created before the batch failed to be removed, but %s were removed", deleted)
+	}
+
+	if ids := instance.modelIds(); ids != "dtmi:test:a;1" {
+		t.Errorf("Expected only the model which already existed to be left in the instance, but the instance has %s", ids)
+	}
+}
+
+func Test_uploadModels_atomicRollbackFails(t *testing.T) {
+	instance := &fakeInstance{failUpload: 3, failDelete: "dtmi:test:c;1"}
+	client, source := newRollbackTest(t, instance)
+	journalPath := filepath.Join(t.TempDir(), "upload.jsonl")
+
+	options := UploadOptions{JournalPath: journalPath, MaxRequestBytes: 100, Atomic: true}
+	err := uploadModels(context.Background(), client, "https://example.api.weu.digitaltwins.azure.net", source, options)
+	if err == nil || !strings.Contains(err.Error(), "DTDLParserError") ||
+		!strings.Contains(err.Error(), "unable to remove the 2 model(s) created by the upload") || !strings.Contains(err.Error(), "ModelReferencesNotDeleted") {
+		t.Errorf("Expected the rollback failure to be reported with the upload error, but got: %v", err)
+	}
+
+	if ids := instance.modelIds(); ids != "dtmi:test:a;1,dtmi:test:b;1,dtmi:test:c;1" {
+		t.Errorf("Expected the models to be left as they were when the rollback failed, but the instance has %s", ids)
+	}
+
+	if !journalExists(journalPath) {
+		t.Errorf("Expected the journal to be kept so that the upload can be resumed")
+	}
+}
+
+// Creates a client for an instance holding a chain of models, each extending the one before it, and a model which
+// nothing depends on. The instance is not protected and deletions do not need to be confirmed
+func newDeleteTest(t *testing.T, instance *fakeInstance) *client {
+	t.Setenv(configFileVariable, filepath.Join(t.TempDir(), "config.json"))
+	t.Setenv(protectedEndpointsVariable, "")
+
+	return newFakeInstanceClient(t, instance,
+		newTestEntry(t, `{"@id": "dtmi:test:a;1", "@type": "Interface"}`),
+		newTestEntry(t, `{"@id": "dtmi:test:b;1", "@type": "Interface", "extends": "dtmi:test:a;1"}`),
+		newTestEntry(t, `{"@id": "dtmi:test:c;1", "@type": "Interface", "extends": "dtmi:test:b;1"}`),
+		newTestEntry(t, `{"@id": "dtmi:test:x;1", "@type": "Interface"}`),
+	)
+}
+
+func Test_deleteModels_dependents(t *testing.T) {
+	instance := &fakeInstance{}
+	client := newDeleteTest(t, instance)
+
+	err := deleteModels(context.Background(), client, []string{"dtmi:test:a;1"}, DeleteOptions{Confirmed: true, Concurrency: 1})
+
+	var dependentsErr *DependentModelsError
+	if !errors.As(err, &dependentsErr) {
+		t.Fatalf("Expected a DependentModelsError, but got: %v", err)
+	}
+	if len(dependentsErr.Dependents) != 2 || !reflect.DeepEqual(dependentsErr.Dependents["dtmi:test:b;1"], []string{"dtmi:test:a;1"}) {
+		t.Errorf("Expected both dependents to be reported, but got %v", dependentsErr.Dependents)
+	}
+
+	if len(instance.deleted) > 0 {
+		t.Errorf("Expected nothing to be removed, but %v were removed", instance.deleted)
+	}
+}
+
+func Test_deleteModels_cascade(t *testing.T) {
+	instance := &fakeInstance{}
+	client := newDeleteTest(t, instance)
+
+	err := deleteModels(context.Background(), client, []string{"dtmi:test:a;1"}, DeleteOptions{Cascade: true, Confirmed: true, Concurrency: 1})
+	if err != nil {
+		t.Fatalf("Expected the models to be removed, but got error: %s", err)
+	}
+
+	if deleted := strings.Join(instance.deleted, ","); deleted != "dtmi:test:c;1,dtmi:test:b;1,dtmi:test:a;1" {
+		t.Errorf("Expected the dependents to be removed before the models they depend on, but %s were removed", deleted)
+	}
+
+	if ids := instance.modelIds(); ids != "dtmi:test:x;1" {
+		t.Errorf("Expected the unrelated model to be left in the instance, but the instance has %s", ids)
+	}
+}
+
+func Test_deleteModels_dryRun(t *testing.T) {
+	instance := &fakeInstance{}
+	client := newDeleteTest(t, instance)
+	client.dryRun = true
+
+	err := deleteModels(context.Background(), client, []string{"dtmi:test:a;1"}, DeleteOptions{Cascade: true, DryRun: true, Concurrency: 1})
+	if err != nil {
+		t.Fatalf("Expected the dry run to succeed, but got error: %s", err)
+	}
+
+	if len(instance.deleted) > 0 {
+		t.Errorf("Expected nothing to be removed in a dry run, but %v were removed", instance.deleted)
+	}
+}
+
+func Test_deleteModels_alreadyRemoved(t *testing.T) {
+	instance := &fakeInstance{removed: "dtmi:test:c;1"}
+	client := newDeleteTest(t, instance)
+
+	err := deleteModels(context.Background(), client, []string{"dtmi:test:b;1", "dtmi:test:c;1"}, DeleteOptions{Confirmed: true, Concurrency: 1})
+	if err != nil {
+		t.Fatalf("Expected a model which has already been removed to be skipped, but got error: %s", err)
+	}
+
+	if deleted := strings.Join(instance.deleted, ","); deleted != "dtmi:test:b;1" {
+		t.Errorf("Expected the remaining model to be removed, but %s were removed", deleted)
+	}
+}
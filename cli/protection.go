@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	protectedEndpointsVariable = "ADT_PROTECTED_ENDPOINTS" // Environment variable holding a comma separated list of protected endpoints
+	configFileVariable         = "ADT_CONFIG"              // Environment variable which overrides the location of the configuration file
+)
+
+// toolConfiguration defines the contents of the configuration file
+type toolConfiguration struct {
+	ProtectedEndpoints []string `json:"protectedEndpoints"` // Host name patterns of instances which destructive commands should refuse to run against
+}
+
+// Gets the location of the configuration file, which is "adt/config.json" in the user's configuration directory
+// unless overridden by the ADT_CONFIG environment variable
+func configFilePath() (string, error) {
+	if configPath := os.Getenv(configFileVariable); len(configPath) > 0 {
+		return configPath, nil
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, "adt", "config.json"), nil
+}
+
+// Reads the list of protected endpoint patterns from the ADT_PROTECTED_ENDPOINTS environment variable and the
+// configuration file, if it exists
+func loadProtectedEndpoints() ([]string, error) {
+	patterns := make([]string, 0)
+
+	for _, pattern := range strings.Split(os.Getenv(protectedEndpointsVariable), ",") {
+		if pattern = strings.TrimSpace(pattern); len(pattern) > 0 {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	configPath, err := configFilePath()
+	if err != nil {
+		return patterns, nil
+	}
+
+	content, err := os.ReadFile(configPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return patterns, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read configuration file %s: %s", configPath, err)
+	}
+
+	var config toolConfiguration
+	if err = json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("unable to parse configuration file %s: %s", configPath, err)
+	}
+
+	return append(patterns, config.ProtectedEndpoints...), nil
+}
+
+// Checks the host against the protected endpoint patterns, returning the first pattern which matches. Patterns are
+// host names which may contain wildcards (e.g. "*.prod.*"), and may optionally include the https:// scheme
+func matchProtectedEndpoint(host string, patterns []string) (string, bool) {
+	host = strings.ToLower(host)
+
+	for _, pattern := range patterns {
+		hostPattern := strings.ToLower(pattern)
+		if index := strings.Index(hostPattern, "://"); index >= 0 {
+			hostPattern = hostPattern[index+3:]
+		}
+		hostPattern = strings.TrimRight(hostPattern, "/")
+
+		if matched, err := path.Match(hostPattern, host); err == nil && matched {
+			return pattern, true
+		}
+	}
+
+	return "", false
+}
+
+// Checks that a destructive operation is allowed to run against the host. If the host matches a protected endpoint
+// then an error is returned unless allowProtected is set
+func checkProtectedEndpoint(host string, allowProtected bool) error {
+	patterns, err := loadProtectedEndpoints()
+	if err != nil {
+		return err
+	}
+
+	if pattern, ok := matchProtectedEndpoint(host, patterns); ok && !allowProtected {
+		return fmt.Errorf("%s is a protected endpoint (matches '%s'), the -allow-protected flag must be given to run this command against it", host, pattern)
+	}
+
+	return nil
+}
+
+// Asks the user to confirm a destructive operation by typing in the host name of the instance. If the context is
+// cancelled while waiting for a response then the operation is not confirmed
+func confirmHostname(ctx context.Context, host string, prompt string, in io.Reader, out io.Writer) error {
+	_, _ = fmt.Fprintln(out, prompt)
+	_, _ = fmt.Fprintf(out, "Type the host name of the instance (%s) to confirm: ", host)
+
+	type readResult struct {
+		response string
+		err      error
+	}
+
+	results := make(chan readResult, 1)
+	go func() {
+		response, err := bufio.NewReader(in).ReadString('\n')
+		results <- readResult{response, err}
+	}()
+
+	var response string
+	var err error
+	select {
+	case <-ctx.Done():
+		_, _ = fmt.Fprintln(out)
+		return fmt.Errorf("cancelled before confirmation was received, no changes have been made: %w", ctx.Err())
+	case result := <-results:
+		response, err = result.response, result.err
+	}
+
+	if err != nil && !(errors.Is(err, io.EOF) && len(response) > 0) {
+		return fmt.Errorf("no confirmation received, use the -yes flag to run without a confirmation prompt")
+	}
+
+	if !strings.EqualFold(strings.TrimSpace(response), host) {
+		return fmt.Errorf("the host name entered does not match %s, no changes have been made", host)
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_matchProtectedEndpoint(t *testing.T) {
+	patterns := []string{"https://prod-twin.api.weu.digitaltwins.azure.net/", "*-prd.api.*.digitaltwins.azure.net"}
+
+	tests := []struct {
+		host      string
+		protected bool
+	}{
+		{host: "prod-twin.api.weu.digitaltwins.azure.net", protected: true},
+		{host: "PROD-TWIN.api.weu.digitaltwins.azure.net", protected: true},
+		{host: "sales-prd.api.neu.digitaltwins.azure.net", protected: true},
+		{host: "sales-dev.api.neu.digitaltwins.azure.net", protected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+			if _, protected := matchProtectedEndpoint(test.host, patterns); protected != test.protected {
+				t.Errorf("Expected protected to be %v, but got %v", test.protected, protected)
+			}
+		})
+	}
+}
+
+func Test_loadProtectedEndpoints(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	_ = os.WriteFile(configPath, []byte(`{"protectedEndpoints": ["from-file.api.weu.digitaltwins.azure.net"]}`), 0644)
+
+	t.Setenv(configFileVariable, configPath)
+	t.Setenv(protectedEndpointsVariable, " from-env-1.api.weu.digitaltwins.azure.net, ,from-env-2.api.weu.digitaltwins.azure.net")
+
+	patterns, err := loadProtectedEndpoints()
+	if err != nil {
+		t.Fatalf("Expected protected endpoints, but got error: %s", err)
+	}
+
+	expected := []string{
+		"from-env-1.api.weu.digitaltwins.azure.net",
+		"from-env-2.api.weu.digitaltwins.azure.net",
+		"from-file.api.weu.digitaltwins.azure.net",
+	}
+	if strings.Join(patterns, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v, but got %v", expected, patterns)
+	}
+
+	err = checkProtectedEndpoint("from-file.api.weu.digitaltwins.azure.net", false)
+	assertExpectedError(t, err, errorText("is a protected endpoint"))
+
+	err = checkProtectedEndpoint("from-file.api.weu.digitaltwins.azure.net", true)
+	assertExpectedError(t, err, nil)
+}
+
+func Test_confirmHostname(t *testing.T) {
+	host := "my-twin.api.weu.digitaltwins.azure.net"
+
+	tests := []struct {
+		name          string
+		input         string
+		expectedError *string
+	}{
+		{name: "Matching", input: host + "\n", expectedError: nil},
+		{name: "MatchingWithoutNewLine", input: host, expectedError: nil},
+		{name: "NotMatching", input: "other-twin.api.weu.digitaltwins.azure.net\n", expectedError: errorText("does not match")},
+		{name: "NoInput", input: "", expectedError: errorText("no confirmation received")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := confirmHostname(context.Background(), host, "Removing models", strings.NewReader(test.input), &out)
+			assertExpectedError(t, err, test.expectedError)
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+)
+
+// referenceKind describes how one model refers to another
+type referenceKind int
+
+const (
+	extendsReference      referenceKind = iota // The model extends the referenced interface
+	componentReference                         // The model embeds the referenced interface as a component
+	relationshipReference                      // The model has a relationship which targets the referenced interface
+	schemaReference                            // The model uses a schema defined by the referenced element
+)
+
+// String returns the name of the reference kind
+func (kind referenceKind) String() string {
+	switch kind {
+	case extendsReference:
+		return "extends"
+	case componentReference:
+		return "component"
+	case relationshipReference:
+		return "relationship"
+	case schemaReference:
+		return "schema"
+	default:
+		return "unknown"
+	}
+}
+
+// modelReference is a reference from a model to an element which is defined outside of that model
+type modelReference struct {
+	modelId string        // The DTMI being referenced
+	kind    referenceKind // How the DTMI is referenced
+	pointer string        // JSON pointer to where the reference is made in the model
+}
+
+// Walks a DTDL v2 or v3 interface collecting all the DTMIs it references, along with the ids of every element which
+// is defined inline so that they can be excluded from the external references
+type referenceWalker struct {
+	references []modelReference // All references found, in the order they were found
+	definedIds map[string]bool  // The ids of all elements defined within the interface
+}
+
+// Returns all the distinct references from the interface to elements which are not defined inside the interface
+func getModelReferences(object jsonObject) []modelReference {
+	walker := referenceWalker{
+		references: make([]modelReference, 0),
+		definedIds: make(map[string]bool),
+	}
+	walker.walkInterface(object, "")
+
+	check := make(map[modelReference]bool)
+	distinct := make([]modelReference, 0)
+
+	for _, reference := range walker.references {
+		key := modelReference{modelId: reference.modelId, kind: reference.kind}
+		if walker.definedIds[reference.modelId] || check[key] {
+			continue
+		}
+		check[key] = true
+		distinct = append(distinct, reference)
+	}
+
+	return distinct
+}
+
+// Returns the ids of the interface and every element defined inside it
+func getDefinedIds(object jsonObject) []string {
+	walker := referenceWalker{
+		references: make([]modelReference, 0),
+		definedIds: make(map[string]bool),
+	}
+	walker.walkInterface(object, "")
+
+	ids := make([]string, 0, len(walker.definedIds))
+	for id := range walker.definedIds {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Adds a reference to the collection if the value is a DTMI
+func (walker *referenceWalker) addReference(value interface{}, kind referenceKind, pointer string) {
+	if id, ok := value.(string); ok && isDtmi(id) {
+		walker.references = append(walker.references, modelReference{modelId: id, kind: kind, pointer: pointer})
+	}
+}
+
+// Records the id of an element defined inline
+func (walker *referenceWalker) define(element map[string]interface{}) {
+	id, ok := element["@id"].(string)
+	if !ok {
+		id, ok = element["id"].(string)
+	}
+	if ok && isDtmi(id) {
+		walker.definedIds[id] = true
+	}
+}
+
+// Walks an interface, which may be either the top level model or one defined inline by an extends or component
+func (walker *referenceWalker) walkInterface(element map[string]interface{}, pointer string) {
+	walker.define(element)
+
+	for i, item := range asArray(element["extends"]) {
+		itemPointer := arrayPointer(element["extends"], pointer+"/extends", i)
+		switch extends := item.(type) {
+		case string:
+			walker.addReference(extends, extendsReference, itemPointer)
+		case map[string]interface{}:
+			walker.walkInterface(extends, itemPointer)
+		}
+	}
+
+	for i, item := range asArray(element["contents"]) {
+		if content, ok := item.(map[string]interface{}); ok {
+			walker.walkContent(content, arrayPointer(element["contents"], pointer+"/contents", i))
+		}
+	}
+
+	for i, item := range asArray(element["schemas"]) {
+		if schema, ok := item.(map[string]interface{}); ok {
+			walker.walkSchema(schema, arrayPointer(element["schemas"], pointer+"/schemas", i))
+		}
+	}
+}
+
+// Walks an item from the contents of an interface
+func (walker *referenceWalker) walkContent(content map[string]interface{}, pointer string) {
+	walker.define(content)
+
+	switch {
+	case hasType(content, "Component"):
+		switch schema := content["schema"].(type) {
+		case string:
+			walker.addReference(schema, componentReference, pointer+"/schema")
+		case map[string]interface{}:
+			walker.walkInterface(schema, pointer+"/schema")
+		}
+	case hasType(content, "Relationship"):
+		walker.addReference(content["target"], relationshipReference, pointer+"/target")
+		for i, item := range asArray(content["properties"]) {
+			if property, ok := item.(map[string]interface{}); ok {
+				walker.walkContent(property, arrayPointer(content["properties"], pointer+"/properties", i))
+			}
+		}
+		walker.walkSchemaValue(content["schema"], pointer+"/schema")
+	case hasType(content, "Command"):
+		for _, key := range []string{"request", "response"} {
+			if payload, ok := content[key].(map[string]interface{}); ok {
+				walker.define(payload)
+				walker.walkSchemaValue(payload["schema"], pointer+"/"+key+"/schema")
+			}
+		}
+	default:
+		walker.walkSchemaValue(content["schema"], pointer+"/schema")
+	}
+}
+
+// Walks a schema value, which is either a reference to a schema by DTMI, a primitive schema name, or an inline
+// complex schema
+func (walker *referenceWalker) walkSchemaValue(value interface{}, pointer string) {
+	switch schema := value.(type) {
+	case string:
+		walker.addReference(schema, schemaReference, pointer)
+	case map[string]interface{}:
+		walker.walkSchema(schema, pointer)
+	}
+}
+
+// Walks a complex schema (Array, Enum, Map or Object) looking for any schemas it references
+func (walker *referenceWalker) walkSchema(schema map[string]interface{}, pointer string) {
+	walker.define(schema)
+
+	walker.walkSchemaValue(schema["elementSchema"], pointer+"/elementSchema")
+	walker.walkSchemaValue(schema["valueSchema"], pointer+"/valueSchema")
+
+	for _, key := range []string{"mapKey", "mapValue"} {
+		if mapPart, ok := schema[key].(map[string]interface{}); ok {
+			walker.define(mapPart)
+			walker.walkSchemaValue(mapPart["schema"], pointer+"/"+key+"/schema")
+		}
+	}
+
+	for i, item := range asArray(schema["fields"]) {
+		if field, ok := item.(map[string]interface{}); ok {
+			walker.define(field)
+			walker.walkSchemaValue(field["schema"], arrayPointer(schema["fields"], pointer+"/fields", i)+"/schema")
+		}
+	}
+
+	for _, item := range asArray(schema["enumValues"]) {
+		if enumValue, ok := item.(map[string]interface{}); ok {
+			walker.define(enumValue)
+		}
+	}
+}
+
+// Checks if the element has the given @type, either as its only type or as one of an array of co-types
+func hasType(element map[string]interface{}, typeName string) bool {
+	for _, item := range asArray(element["@type"]) {
+		if value, ok := item.(string); ok && value == typeName {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the value as an array, wrapping single values so that properties which may hold either a single value or
+// an array of values can be handled in the same way
+func asArray(value interface{}) []interface{} {
+	switch value := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return value
+	default:
+		return []interface{}{value}
+	}
+}
+
+// Returns the JSON pointer for an item of a property which may be either a single value or an array of values
+func arrayPointer(value interface{}, pointer string, index int) string {
+	if _, ok := value.([]interface{}); ok {
+		return fmt.Sprintf("%s/%d", pointer, index)
+	}
+	return pointer
+}
+
+// Checks if the value looks like a digital twin model identifier
+func isDtmi(value string) bool {
+	return strings.HasPrefix(value, "dtmi:")
+}
@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy defines how requests to the Azure Digital Twin instance are retried when they are throttled or fail with
+// a transient error. The zero value does not retry requests
+type RetryPolicy struct {
+	MaxRetries    int           // The maximum number of times a request is retried
+	BaseDelay     time.Duration // The delay before the first retry, which is doubled for each subsequent retry
+	MaxDelay      time.Duration // The maximum delay between retries, unless the service asks for longer with Retry-After
+	MaxRetryAfter time.Duration // The maximum delay the service can ask for with Retry-After, with zero meaning MaxDelay
+}
+
+// DefaultRetryPolicy returns the retry policy used when no other policy is specified
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries:    5,
+		BaseDelay:     time.Second,
+		MaxDelay:      time.Minute,
+		MaxRetryAfter: 5 * time.Minute,
+	}
+}
+
+// Checks if a request should be retried based on its response or error. Throttled and unavailable responses are always
+// retried as the service has not processed the request. Other failures are only retried for idempotent methods, as a
+// request which was not idempotent (such as the POST used to create models) may have been processed before the failure
+func shouldRetry(method string, resp *http.Response, err error) bool {
+	if err != nil {
+		return isIdempotent(method)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return isIdempotent(method)
+	default:
+		return false
+	}
+}
+
+// Checks if sending the same request multiple times has the same effect as sending it once. PATCH is included as the
+// only patch this client sends replaces the decommissioned flag with a fixed value
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodPatch:
+		return true
+	default:
+		return false
+	}
+}
+
+// Gets the delay before the next retry. If the response includes a Retry-After header then it is honoured up to
+// MaxRetryAfter, otherwise an exponential backoff with jitter is used
+func (policy RetryPolicy) delay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			maxRetryAfter := policy.MaxRetryAfter
+			if maxRetryAfter <= 0 {
+				maxRetryAfter = policy.MaxDelay
+			}
+			if retryAfter > maxRetryAfter {
+				log.Printf("The instance asked to wait %s before retrying, which is longer than the maximum of %s", retryAfter, maxRetryAfter)
+				return maxRetryAfter
+			}
+			return retryAfter
+		}
+	}
+
+	backoff := policy.BaseDelay
+	for i := 0; i < attempt && backoff < policy.MaxDelay; i++ {
+		backoff *= 2
+	}
+	if policy.MaxDelay > 0 && backoff > policy.MaxDelay {
+		backoff = policy.MaxDelay
+	}
+
+	// Use a random delay between half and all of the backoff so that parallel clients do not retry in step
+	half := backoff / 2
+	if half <= 0 {
+		return backoff
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// Parses the value of a Retry-After header, which is either a number of seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if len(value) == 0 {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := date.Sub(now); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,195 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"Empty", "", 0, false},
+		{"Seconds", "3", 3 * time.Second, true},
+		{"Negative seconds", "-1", 0, false},
+		{"HTTP date", "Sat, 01 Oct 2022 12:00:10 GMT", 10 * time.Second, true},
+		{"HTTP date in the past", "Sat, 01 Oct 2022 11:59:00 GMT", 0, true},
+		{"Invalid", "soon", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, ok := parseRetryAfter(tt.value, now)
+			if ok != tt.ok || delay != tt.expected {
+				t.Errorf("Expected (%s, %t) but got (%s, %t)", tt.expected, tt.ok, delay, ok)
+			}
+		})
+	}
+}
+
+func Test_shouldRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		status   int
+		err      error
+		expected bool
+	}{
+		{"Throttled GET", http.MethodGet, http.StatusTooManyRequests, nil, true},
+		{"Throttled POST", http.MethodPost, http.StatusTooManyRequests, nil, true},
+		{"Unavailable POST", http.MethodPost, http.StatusServiceUnavailable, nil, true},
+		{"Server error DELETE", http.MethodDelete, http.StatusInternalServerError, nil, true},
+		{"Server error POST", http.MethodPost, http.StatusInternalServerError, nil, false},
+		{"Network error GET", http.MethodGet, 0, errors.New("connection reset"), true},
+		{"Network error POST", http.MethodPost, 0, errors.New("connection reset"), false},
+		{"Bad request", http.MethodGet, http.StatusBadRequest, nil, false},
+		{"Success", http.MethodGet, http.StatusOK, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp *http.Response
+			if tt.err == nil {
+				resp = &http.Response{StatusCode: tt.status}
+			}
+
+			if actual := shouldRetry(tt.method, resp, tt.err); actual != tt.expected {
+				t.Errorf("Expected %t but got %t", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_delay(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 10, BaseDelay: time.Second, MaxDelay: 8 * time.Second, MaxRetryAfter: time.Minute}
+
+	for attempt := 0; attempt < 10; attempt++ {
+		delay := policy.delay(attempt, nil)
+		if delay > policy.MaxDelay {
+			t.Errorf("Expected the delay for attempt %d to be at most %s, but got %s", attempt, policy.MaxDelay, delay)
+		}
+		if delay < policy.BaseDelay/2 {
+			t.Errorf("Expected the delay for attempt %d to be at least %s, but got %s", attempt, policy.BaseDelay/2, delay)
+		}
+	}
+
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"20"}}}
+	if delay := policy.delay(0, resp); delay != 20*time.Second {
+		t.Errorf("Expected the Retry-After header to be honoured, but got a delay of %s", delay)
+	}
+
+	resp = &http.Response{Header: http.Header{"Retry-After": []string{"86400"}}}
+	if delay := policy.delay(0, resp); delay != time.Minute {
+		t.Errorf("Expected the Retry-After header to be limited to the maximum Retry-After, but got a delay of %s", delay)
+	}
+
+	policy.MaxRetryAfter = 0
+	if delay := policy.delay(0, resp); delay != policy.MaxDelay {
+		t.Errorf("Expected the Retry-After header to be limited to the maximum delay, but got a delay of %s", delay)
+	}
+}
+
+func Test_client_sendRetriesThrottledRequests(t *testing.T) {
+	responses := []int{http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusOK}
+	attempt := 0
+	client, requestCount := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Expected the authorization header to be sent on every attempt")
+		}
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(responses[attempt])
+		attempt++
+	})
+	client.configuration.retry = RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	resp, err := client.send(context.Background(), http.MethodPost, client.getModelUrl(nil, nil), []byte("[]"), "application/json", &azcore.AccessToken{Token: "token"})
+	if err != nil {
+		t.Fatalf("Expected the request to succeed, but got error: %s", err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected a final status of %d, but got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	if requestCount.count() != 3 {
+		t.Errorf("Expected 3 requests to be sent, but %d were sent", requestCount.count())
+	}
+}
+
+func Test_client_sendStopsAfterMaxRetries(t *testing.T) {
+	client, requestCount := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	client.configuration.retry = RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	resp, err := client.send(context.Background(), http.MethodGet, client.getModelUrl(nil, nil), nil, "", &azcore.AccessToken{Token: "token"})
+	if err != nil {
+		t.Fatalf("Expected a response, but got error: %s", err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected the final throttled response to be returned, but got %d", resp.StatusCode)
+	}
+
+	if requestCount.count() != 3 {
+		t.Errorf("Expected 3 requests to be sent, but %d were sent", requestCount.count())
+	}
+}
+
+func Test_client_sendStopsWaitingWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, requestCount := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	client.configuration.retry = DefaultRetryPolicy()
+
+	start := time.Now()
+	_, err := client.send(ctx, http.MethodGet, client.getModelUrl(nil, nil), nil, "", &azcore.AccessToken{Token: "token"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancellation error, but got: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Expected the retry wait to be abandoned, but the request took %s", elapsed)
+	}
+
+	if requestCount.count() != 1 {
+		t.Errorf("Expected 1 request to be sent, but %d were sent", requestCount.count())
+	}
+}
+
+func Test_cancellationError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := cancellationError(ctx, "deleting 12/300 models")
+	if err.Error() != "cancelled after deleting 12/300 models: context canceled" {
+		t.Errorf("Unexpected error message: %s", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the error to wrap context.Canceled")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	err = cancellationError(ctx, "uploading 1/3 batches")
+	if err.Error() != "timed out after uploading 1/3 batches: context deadline exceeded" {
+		t.Errorf("Unexpected error message: %s", err)
+	}
+}
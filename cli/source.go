@@ -0,0 +1,239 @@
+package cli
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StdinSource is the path used to read models from standard input
+const StdinSource = "-"
+
+// sourceKind identifies how the models of a ModelDirectory are stored
+type sourceKind int
+
+const (
+	directorySource sourceKind = iota // A directory on disk
+	zipSource                         // A zip archive
+	tarSource                         // A gzip compressed tar archive
+	stdinSource                       // Standard input, holding one or more models
+)
+
+// Gets the kind of source for a path, based on its extension. Paths which are not archives or stdin are treated as
+// directories
+func sourceKindOf(sourcePath string) sourceKind {
+	lowerPath := strings.ToLower(sourcePath)
+
+	switch {
+	case sourcePath == StdinSource:
+		return stdinSource
+	case strings.HasSuffix(lowerPath, ".zip"):
+		return zipSource
+	case strings.HasSuffix(lowerPath, ".tar.gz"), strings.HasSuffix(lowerPath, ".tgz"):
+		return tarSource
+	default:
+		return directorySource
+	}
+}
+
+// Opens a file system over the models in the source. Archives and stdin are read in full, and so should only be opened
+// once
+func openSource(sourcePath string, stdin io.Reader) (fs.FS, error) {
+	switch sourceKindOf(sourcePath) {
+	case stdinSource:
+		content, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read models from stdin: %s", err)
+		}
+		return memoryFS{"stdin.json": content}, nil
+	case zipSource:
+		content, err := os.ReadFile(sourcePath)
+		if err != nil {
+			return nil, err
+		}
+		return zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	case tarSource:
+		file, err := os.Open(sourcePath)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = file.Close() }()
+		return readTarArchive(file)
+	default:
+		return os.DirFS(sourcePath), nil
+	}
+}
+
+// Reads the regular files in a gzip compressed tar archive into memory
+func readTarArchive(reader io.Reader) (fs.FS, error) {
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress archive: %s", err)
+	}
+
+	files := make(memoryFS)
+	archive := tar.NewReader(gzipReader)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to read archive: %s", err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+header.Name), "/")
+		content, err := io.ReadAll(archive)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s from archive: %s", header.Name, err)
+		}
+		files[name] = content
+	}
+
+	return files, nil
+}
+
+// memoryFS is a read only file system held in memory, mapping the slash separated path of each file to its content.
+// Directories are implied by the paths of the files
+type memoryFS map[string][]byte
+
+// Open opens the named file or directory
+func (files memoryFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if content, ok := files[name]; ok {
+		return &memoryFile{info: memoryFileInfo{name: path.Base(name), size: int64(len(content))}, reader: bytes.NewReader(content)}, nil
+	}
+
+	entries, err := files.ReadDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &memoryFile{info: memoryFileInfo{name: path.Base(name), dir: true}, entries: entries}, nil
+}
+
+// ReadDir reads the named directory, returning its entries sorted by name
+func (files memoryFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	prefix := ""
+	if name != "." {
+		prefix = name + "/"
+	}
+
+	children := make(map[string]memoryFileInfo)
+	for filePath, content := range files {
+		if !strings.HasPrefix(filePath, prefix) {
+			continue
+		}
+
+		child, rest, isDir := strings.Cut(strings.TrimPrefix(filePath, prefix), "/")
+		if isDir {
+			children[child] = memoryFileInfo{name: child, dir: true}
+		} else if len(rest) == 0 {
+			children[child] = memoryFileInfo{name: child, size: int64(len(content))}
+		}
+	}
+
+	if len(children) == 0 && name != "." {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, info := range children {
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	return entries, nil
+}
+
+// memoryFile is an open file or directory in a memoryFS
+type memoryFile struct {
+	info    memoryFileInfo // Information about the file
+	reader  *bytes.Reader  // Reads the content of the file, which is nil for a directory
+	entries []fs.DirEntry  // The entries of a directory which have not yet been read
+}
+
+// Stat returns information about the file
+func (file *memoryFile) Stat() (fs.FileInfo, error) {
+	return file.info, nil
+}
+
+// Read reads the content of the file
+func (file *memoryFile) Read(buffer []byte) (int, error) {
+	if file.reader == nil {
+		return 0, &fs.PathError{Op: "read", Path: file.info.name, Err: fs.ErrInvalid}
+	}
+	return file.reader.Read(buffer)
+}
+
+// ReadDir reads the next entries of a directory. If count is zero or less then all remaining entries are returned,
+// otherwise up to count entries are returned and io.EOF once there are none left
+func (file *memoryFile) ReadDir(count int) ([]fs.DirEntry, error) {
+	if !file.info.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: file.info.name, Err: fs.ErrInvalid}
+	}
+
+	if count <= 0 {
+		entries := file.entries
+		file.entries = nil
+		return entries, nil
+	} else if len(file.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > len(file.entries) {
+		count = len(file.entries)
+	}
+	entries := file.entries[:count]
+	file.entries = file.entries[count:]
+	return entries, nil
+}
+
+// Close closes the file
+func (file *memoryFile) Close() error {
+	return nil
+}
+
+// memoryFileInfo describes a file or directory in a memoryFS
+type memoryFileInfo struct {
+	name string // The base name of the file
+	size int64  // The size of the file in bytes
+	dir  bool   // Indicates if this is a directory
+}
+
+// Name returns the base name of the file
+func (info memoryFileInfo) Name() string { return info.name }
+
+// Size returns the size of the file in bytes
+func (info memoryFileInfo) Size() int64 { return info.size }
+
+// ModTime returns the zero time, as files held in memory have no modification time
+func (info memoryFileInfo) ModTime() time.Time { return time.Time{} }
+
+// IsDir indicates if this is a directory
+func (info memoryFileInfo) IsDir() bool { return info.dir }
+
+// Sys returns nil, as there is no underlying data source
+func (info memoryFileInfo) Sys() interface{} { return nil }
+
+// Mode returns the file mode, which is read only
+func (info memoryFileInfo) Mode() fs.FileMode {
+	if info.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// The files in the multi-model test data, which are written into the test archives
+var archiveTestFiles = []string{"ontology.json", "rooms.ndjson"}
+
+// Writes the multi-model test data into a zip archive
+func writeTestZip(t *testing.T, archivePath string) {
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+
+	archive := zip.NewWriter(file)
+	for _, name := range archiveTestFiles {
+		content, _ := os.ReadFile(filepath.Join("../testdata/multi", name))
+		writer, err := archive.Create("ontology/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = writer.Write(content)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Writes the multi-model test data into a gzip compressed tar archive, along with a file which is not a model file
+func writeTestTar(t *testing.T, archivePath string) {
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+
+	gzipWriter := gzip.NewWriter(file)
+	archive := tar.NewWriter(gzipWriter)
+
+	_ = archive.WriteHeader(&tar.Header{Name: "./ontology/", Typeflag: tar.TypeDir, Mode: 0755})
+	for _, name := range append(archiveTestFiles, "README.md") {
+		content, _ := os.ReadFile(filepath.Join("../testdata/multi", name))
+		_ = archive.WriteHeader(&tar.Header{Name: "./ontology/" + name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))})
+		_, _ = archive.Write(content)
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModelDirectory_getModels_sources(t *testing.T) {
+	dir := t.TempDir()
+	writeTestZip(t, filepath.Join(dir, "ontology.zip"))
+	writeTestTar(t, filepath.Join(dir, "ontology.tar.gz"))
+
+	for _, name := range []string{"ontology.zip", "ontology.tar.gz"} {
+		t.Run(name, func(t *testing.T) {
+			d := ModelDirectory{}
+			if err := d.Set(filepath.Join(dir, name)); err != nil {
+				t.Fatalf("Expected the archive to be accepted, but got error: %s", err)
+			}
+
+			models, err := d.getModels()
+			if err != nil {
+				t.Fatalf("Expected a collection of models, but received error: %s", err)
+			}
+
+			ids := make([]string, len(models))
+			for i, m := range models {
+				ids[i] = m.modelId
+			}
+			sort.Strings(ids)
+
+			expected := "dtmi:digitaltwins:testing:multi:building;1,dtmi:digitaltwins:testing:multi:meetingroom;1,dtmi:digitaltwins:testing:multi:room;1,dtmi:digitaltwins:testing:multi:space;1"
+			if strings.Join(ids, ",") != expected {
+				t.Errorf("Expected models %s, but got %s", expected, strings.Join(ids, ","))
+			}
+
+			expectedPath := filepath.Join(dir, name, "ontology", "ontology.json")
+			if models[0].sourcePath != expectedPath {
+				t.Errorf("Expected the first model to be read from %s, but got %s", expectedPath, models[0].sourcePath)
+			}
+		})
+	}
+}
+
+func Test_openSource_stdin(t *testing.T) {
+	source, err := openSource(StdinSource, strings.NewReader(`[{"@id": "dtmi:test:a;1"}, {"@id": "dtmi:test:b;1"}]`))
+	if err != nil {
+		t.Fatalf("Expected stdin to be read, but got error: %s", err)
+	}
+
+	content, err := fs.ReadFile(source, "stdin.json")
+	if err != nil || !strings.Contains(string(content), "dtmi:test:b;1") {
+		t.Errorf("Expected the content of stdin to be readable, but got %q (%v)", content, err)
+	}
+
+	if d := (ModelDirectory{Path: StdinSource}); d.filePath("stdin.json") != "<stdin>" {
+		t.Errorf("Expected models from stdin to be reported as <stdin>, but got %s", d.filePath("stdin.json"))
+	}
+}
+
+func Test_memoryFS(t *testing.T) {
+	files := memoryFS{
+		"space.json":            []byte(`{"@id": "dtmi:test:space;1"}`),
+		"dtmi/test/room.json":   []byte(`{"@id": "dtmi:test:room;1"}`),
+		"dtmi/test/level.dtdl":  []byte(`{"@id": "dtmi:test:level;1"}`),
+		"dtmi/other/floor.json": []byte(`{"@id": "dtmi:other:floor;1"}`),
+	}
+
+	if err := fstest.TestFS(files, "space.json", "dtmi/test/room.json", "dtmi/test/level.dtdl", "dtmi/other/floor.json"); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := files.Open("dtmi/missing"); err == nil {
+		t.Errorf("Expected an error opening a directory which does not exist")
+	}
+
+	reader, _ := files.Open("dtmi")
+	if _, err := io.ReadAll(reader); err == nil {
+		t.Errorf("Expected an error reading a directory")
+	}
+}
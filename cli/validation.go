@@ -0,0 +1,544 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type diagnosticSeverity int
+
+const (
+	errorSeverity   diagnosticSeverity = iota // The model is not valid and will be rejected by the service
+	warningSeverity                           // The model may not behave as expected
+)
+
+// String returns the name of the severity
+func (severity diagnosticSeverity) String() string {
+	if severity == warningSeverity {
+		return "warning"
+	}
+	return "error"
+}
+
+// diagnostic describes a problem found at a specific location in a model file
+type diagnostic struct {
+	path     string             // The path of the file containing the problem
+	line     int                // The line in the file where the problem was found
+	column   int                // The column in the line where the problem was found
+	severity diagnosticSeverity // How serious the problem is
+	message  string             // Description of the problem
+}
+
+// String returns the diagnostic in the "path:line:column: severity: message" format understood by most editors
+func (d diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s", d.path, d.line, d.column, d.severity, d.message)
+}
+
+// dtdlVersion describes the rules and limits of a version of the DTDL language
+type dtdlVersion struct {
+	context              string         // The @context value which identifies the version
+	dtmiPattern          *regexp.Regexp // Pattern which identifiers must match
+	namePattern          *regexp.Regexp // Pattern which content names must match
+	maxNameLength        int            // Maximum length of a content name
+	maxInterfaceIdLength int            // Maximum length of an interface identifier
+	maxIdLength          int            // Maximum length of any other identifier
+	maxContents          int            // Maximum number of contents in an interface
+	maxExtends           int            // Maximum number of interfaces an interface can directly extend
+	maxExtendsDepth      int            // Maximum depth of the extends hierarchy
+}
+
+const dtmiSegment = `(?:[A-Za-z]|_+[A-Za-z0-9])(?:[A-Za-z0-9_]*[A-Za-z0-9])?`
+
+var (
+	dtdlV2 = &dtdlVersion{
+		context:              "dtmi:dtdl:context;2",
+		dtmiPattern:          regexp.MustCompile(`^dtmi:` + dtmiSegment + `(?::` + dtmiSegment + `)*;[1-9][0-9]{0,8}$`),
+		namePattern:          regexp.MustCompile(`^[A-Za-z](?:[A-Za-z0-9_]{0,62}[A-Za-z0-9])?$`),
+		maxNameLength:        64,
+		maxInterfaceIdLength: 128,
+		maxIdLength:          2048,
+		maxContents:          300,
+		maxExtends:           2,
+		maxExtendsDepth:      10,
+	}
+	dtdlV3 = &dtdlVersion{
+		context:              "dtmi:dtdl:context;3",
+		dtmiPattern:          regexp.MustCompile(`^dtmi:` + dtmiSegment + `(?::` + dtmiSegment + `)*(?:;[1-9][0-9]{0,8}(?:\.[0-9]{1,6})?)?$`),
+		namePattern:          regexp.MustCompile(`^[A-Za-z](?:[A-Za-z0-9_]{0,510}[A-Za-z0-9])?$`),
+		maxNameLength:        512,
+		maxInterfaceIdLength: 2048,
+		maxIdLength:          2048,
+		maxContents:          100000,
+		maxExtends:           1024,
+		maxExtendsDepth:      12,
+	}
+)
+
+var (
+	contentTypes = []string{"Property", "Telemetry", "Command", "Relationship", "Component"} // Types allowed in the contents of an interface
+	schemaTypes  = []string{"Array", "Enum", "Map", "Object"}                                // Types allowed in the schemas of an interface
+)
+
+// validatedModel is a model which could be read during validation, along with where it was read from
+type validatedModel struct {
+	entry     *modelEntry    // The model
+	path      string         // The path of the file containing the model
+	positions *jsonPositions // The positions of elements in the file
+	pointer   string         // JSON pointer to the model within the file
+	version   *dtdlVersion   // The DTDL version of the model
+}
+
+// Locates an element in the model by its JSON pointer
+func (model *validatedModel) locate(pointer string) (string, int, int) {
+	line, column := model.positions.locate(model.pointer + pointer)
+	return model.path, line, column
+}
+
+// modelValidator holds the state of validating a set of model files
+type modelValidator struct {
+	diagnostics []diagnostic               // The problems found
+	models      []*validatedModel          // The models which could be read
+	byId        map[string]*validatedModel // The first model read for each model id
+	definitions map[string]string          // Where each @id has been defined, for reporting duplicates
+	current     *validatedModel            // The model currently being validated
+}
+
+// Validates a set of model files, returning any problems found sorted by file and location
+func validateModelFiles(files []modelFile) []diagnostic {
+	validator := modelValidator{
+		diagnostics: make([]diagnostic, 0),
+		models:      make([]*validatedModel, 0),
+		definitions: make(map[string]string),
+		byId:        make(map[string]*validatedModel),
+	}
+
+	for _, file := range files {
+		validator.validateFile(file)
+	}
+
+	validator.validateReferences()
+
+	sort.SliceStable(validator.diagnostics, func(i, j int) bool {
+		a, b := validator.diagnostics[i], validator.diagnostics[j]
+		if a.path != b.path {
+			return a.path < b.path
+		} else if a.line != b.line {
+			return a.line < b.line
+		}
+		return a.column < b.column
+	})
+
+	return validator.diagnostics
+}
+
+// Adds a diagnostic for the file as a whole
+func (validator *modelValidator) addFileDiagnostic(path string, line int, column int, message string) {
+	validator.diagnostics = append(validator.diagnostics, diagnostic{
+		path:     path,
+		line:     line,
+		column:   column,
+		severity: errorSeverity,
+		message:  message,
+	})
+}
+
+// Adds a diagnostic for an element of the current model
+func (validator *modelValidator) report(severity diagnosticSeverity, pointer string, format string, args ...interface{}) {
+	path, line, column := validator.current.locate(pointer)
+	validator.diagnostics = append(validator.diagnostics, diagnostic{
+		path:     path,
+		line:     line,
+		column:   column,
+		severity: severity,
+		message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// Reads and validates a single model file
+func (validator *modelValidator) validateFile(file modelFile) {
+	if file.err != nil {
+		validator.addFileDiagnostic(file.path, 1, 1, fmt.Sprintf("unable to read file: %s", file.err))
+		return
+	}
+
+	documents, err := decodeModelDocuments(file.content)
+	if err != nil {
+		line, column := 1, 1
+		if offset, ok := errorOffset(err); ok && offset > 0 {
+			line, column = indexJsonPositions(file.content).lineAndColumn(offset - 1)
+		}
+		validator.addFileDiagnostic(file.path, line, column, fmt.Sprintf("file does not contain valid JSON: %s", err))
+		return
+	}
+
+	if len(documents) == 0 {
+		validator.addFileDiagnostic(file.path, 1, 1, "file must contain a JSON object describing a DTDL interface")
+		return
+	}
+
+	// Models in the same array share the positions of the array
+	positions := make(map[int64]*jsonPositions)
+	for _, document := range documents {
+		if _, ok := positions[document.start]; !ok {
+			positions[document.start] = indexJsonPositionsAt(file.content, document.start)
+		}
+		model := &validatedModel{path: file.path, positions: positions[document.start], pointer: document.pointer}
+
+		object, ok := document.value.(map[string]interface{})
+		if !ok {
+			path, line, column := model.locate("")
+			validator.addFileDiagnostic(path, line, column, "each model in the file must be a JSON object describing a DTDL interface")
+			continue
+		}
+
+		validator.validateModel(model, object)
+	}
+}
+
+// Validates a top level interface
+func (validator *modelValidator) validateModel(model *validatedModel, object jsonObject) {
+	validator.current = model
+	model.version = validator.validateContext(object)
+
+	idKey := "@id"
+	if _, ok := object[idKey]; !ok {
+		if _, ok := object["id"]; ok {
+			idKey = "id"
+			validator.report(warningSeverity, "/id", "'id' is not a DTDL keyword, use '@id' instead")
+		} else {
+			validator.report(errorSeverity, "", "missing required property '@id'")
+			return
+		}
+	}
+
+	entry, err := newModelEntry(object)
+	if err != nil {
+		validator.report(errorSeverity, "/"+idKey, "%s", err)
+		return
+	}
+	model.entry = entry
+
+	validator.validateId(entry.modelId, "/"+idKey, model.version.maxInterfaceIdLength)
+	validator.validateInterface(object, "")
+
+	validator.models = append(validator.models, model)
+	if _, ok := validator.byId[entry.modelId]; !ok {
+		validator.byId[entry.modelId] = model
+	}
+}
+
+// Validates the @context of a model, returning the DTDL version it declares
+func (validator *modelValidator) validateContext(object jsonObject) *dtdlVersion {
+	key := "@context"
+	value, ok := object[key]
+	if !ok {
+		if value, ok = object["context"]; ok {
+			key = "context"
+			validator.report(warningSeverity, "/context", "'context' is not a DTDL keyword, use '@context' instead")
+		} else {
+			validator.report(errorSeverity, "", "missing required property '@context'")
+			return dtdlV2
+		}
+	}
+
+	for _, item := range asArray(value) {
+		for _, version := range []*dtdlVersion{dtdlV2, dtdlV3} {
+			if item == version.context {
+				return version
+			}
+		}
+	}
+
+	validator.report(errorSeverity, "/"+key, "'%s' must include '%s' or '%s'", key, dtdlV2.context, dtdlV3.context)
+	return dtdlV2
+}
+
+// Validates the syntax and length of an identifier, and records where it was defined
+func (validator *modelValidator) validateId(id string, pointer string, maxLength int) {
+	validator.validateDtmi(id, pointer, maxLength)
+
+	path, line, column := validator.current.locate(pointer)
+	location := fmt.Sprintf("%s:%d:%d", path, line, column)
+
+	if existing, ok := validator.definitions[id]; ok {
+		validator.report(errorSeverity, pointer, "duplicate @id '%s', already defined at %s", id, existing)
+	} else {
+		validator.definitions[id] = location
+	}
+}
+
+// Validates the syntax and length of a DTMI
+func (validator *modelValidator) validateDtmi(id string, pointer string, maxLength int) {
+	version := validator.current.version
+	if !version.dtmiPattern.MatchString(id) {
+		validator.report(errorSeverity, pointer, "'%s' is not a valid DTDL v%s identifier", id, strings.TrimPrefix(version.context, "dtmi:dtdl:context;"))
+	} else if len(id) > maxLength {
+		validator.report(errorSeverity, pointer, "identifier '%s' is %d characters long, the maximum is %d", id, len(id), maxLength)
+	}
+}
+
+// Validates an interface, which is either a top level model or one defined inline
+func (validator *modelValidator) validateInterface(element map[string]interface{}, pointer string) {
+	version := validator.current.version
+
+	if _, ok := element["@type"]; !ok {
+		validator.report(errorSeverity, pointer, "missing required property '@type'")
+	} else if !hasType(element, "Interface") {
+		validator.report(errorSeverity, pointer+"/@type", "@type must be 'Interface'")
+	}
+
+	if pointer != "" {
+		if id, ok := element["@id"].(string); ok {
+			validator.validateId(id, pointer+"/@id", version.maxInterfaceIdLength)
+		}
+	}
+
+	extends := asArray(element["extends"])
+	if len(extends) > version.maxExtends {
+		validator.report(errorSeverity, pointer+"/extends", "an interface can extend at most %d interfaces, but %d are listed", version.maxExtends, len(extends))
+	}
+	for i, item := range extends {
+		itemPointer := arrayPointer(element["extends"], pointer+"/extends", i)
+		switch item := item.(type) {
+		case string:
+			validator.validateDtmi(item, itemPointer, version.maxInterfaceIdLength)
+		case map[string]interface{}:
+			validator.validateInterface(item, itemPointer)
+		default:
+			validator.report(errorSeverity, itemPointer, "extends must be an interface identifier or an inline interface")
+		}
+	}
+
+	if contents, ok := element["contents"]; ok {
+		items, ok := contents.([]interface{})
+		if !ok {
+			validator.report(errorSeverity, pointer+"/contents", "contents must be an array")
+		} else if len(items) > version.maxContents {
+			validator.report(errorSeverity, pointer+"/contents", "an interface can have at most %d contents, but %d are defined", version.maxContents, len(items))
+		}
+
+		names := make(map[string]bool)
+		for i, item := range items {
+			itemPointer := fmt.Sprintf("%s/contents/%d", pointer, i)
+			if content, ok := item.(map[string]interface{}); ok {
+				validator.validateContent(content, itemPointer, names)
+			} else {
+				validator.report(errorSeverity, itemPointer, "contents must only contain objects")
+			}
+		}
+	}
+
+	for i, item := range asArray(element["schemas"]) {
+		itemPointer := arrayPointer(element["schemas"], pointer+"/schemas", i)
+		schema, ok := item.(map[string]interface{})
+		if !ok {
+			validator.report(errorSeverity, itemPointer, "schemas must only contain objects")
+			continue
+		}
+
+		if id, ok := schema["@id"].(string); ok {
+			validator.validateId(id, itemPointer+"/@id", version.maxIdLength)
+		} else {
+			validator.report(errorSeverity, itemPointer, "schemas defined in an interface must have an '@id'")
+		}
+
+		if schemaType := firstType(schema, schemaTypes); schemaType == "" {
+			validator.report(errorSeverity, itemPointer, "@type must be one of %s", strings.Join(schemaTypes, ", "))
+		}
+	}
+}
+
+// Validates an item in the contents of an interface
+func (validator *modelValidator) validateContent(content map[string]interface{}, pointer string, names map[string]bool) {
+	version := validator.current.version
+
+	contentType := ""
+	if _, ok := content["@type"]; !ok {
+		validator.report(errorSeverity, pointer, "missing required property '@type'")
+	} else if contentType = firstType(content, contentTypes); contentType == "" {
+		validator.report(errorSeverity, pointer+"/@type", "@type must include one of %s", strings.Join(contentTypes, ", "))
+	}
+
+	if value, ok := content["name"]; !ok {
+		validator.report(errorSeverity, pointer, "missing required property 'name'")
+	} else if name, ok := value.(string); !ok || !version.namePattern.MatchString(name) {
+		validator.report(errorSeverity, pointer+"/name", "name '%v' is not valid, it must start with a letter, contain only letters, digits and underscores, not end with an underscore, and be no more than %d characters", value, version.maxNameLength)
+	} else if names[name] {
+		validator.report(errorSeverity, pointer+"/name", "duplicate name '%s'", name)
+	} else {
+		names[name] = true
+	}
+
+	if id, ok := content["@id"].(string); ok {
+		validator.validateId(id, pointer+"/@id", version.maxIdLength)
+	}
+
+	switch contentType {
+	case "Property", "Telemetry":
+		if _, ok := content["schema"]; !ok {
+			validator.report(errorSeverity, pointer, "missing required property 'schema'")
+		}
+	case "Component":
+		switch schema := content["schema"].(type) {
+		case nil:
+			validator.report(errorSeverity, pointer, "missing required property 'schema'")
+		case string:
+			validator.validateDtmi(schema, pointer+"/schema", version.maxInterfaceIdLength)
+		case map[string]interface{}:
+			validator.validateInterface(schema, pointer+"/schema")
+		}
+	case "Command":
+		validator.validateCommandPayload(content, "request", "CommandRequest", pointer)
+		validator.validateCommandPayload(content, "response", "CommandResponse", pointer)
+	case "Relationship":
+		if target, ok := content["target"].(string); ok {
+			validator.validateDtmi(target, pointer+"/target", version.maxInterfaceIdLength)
+		}
+
+		propertyNames := make(map[string]bool)
+		for i, item := range asArray(content["properties"]) {
+			itemPointer := arrayPointer(content["properties"], pointer+"/properties", i)
+			if property, ok := item.(map[string]interface{}); ok {
+				validator.validateContent(property, itemPointer, propertyNames)
+			}
+		}
+	}
+}
+
+// Validates the request or response of a command, which when given must be an object with a name and a schema
+func (validator *modelValidator) validateCommandPayload(command map[string]interface{}, property string, payloadType string, pointer string) {
+	version := validator.current.version
+
+	value, ok := command[property]
+	if !ok {
+		return
+	}
+
+	pointer = pointer + "/" + property
+	payload, ok := value.(map[string]interface{})
+	if !ok {
+		validator.report(errorSeverity, pointer, "%s must be an object", property)
+		return
+	}
+
+	if _, ok := payload["@type"]; ok && !hasType(payload, payloadType) {
+		validator.report(errorSeverity, pointer+"/@type", "@type must be '%s'", payloadType)
+	}
+
+	if value, ok := payload["name"]; !ok {
+		validator.report(errorSeverity, pointer, "missing required property 'name'")
+	} else if name, ok := value.(string); !ok || !version.namePattern.MatchString(name) {
+		validator.report(errorSeverity, pointer+"/name", "name '%v' is not valid, it must start with a letter, contain only letters, digits and underscores, not end with an underscore, and be no more than %d characters", value, version.maxNameLength)
+	}
+
+	if id, ok := payload["@id"].(string); ok {
+		validator.validateId(id, pointer+"/@id", version.maxIdLength)
+	}
+
+	switch schema := payload["schema"].(type) {
+	case nil:
+		validator.report(errorSeverity, pointer, "missing required property 'schema'")
+	case string:
+		// Primitive schemas and references to schemas are accepted as they are
+	case map[string]interface{}:
+		if schemaType := firstType(schema, schemaTypes); schemaType == "" {
+			validator.report(errorSeverity, pointer+"/schema", "@type must be one of %s", strings.Join(schemaTypes, ", "))
+		}
+	default:
+		validator.report(errorSeverity, pointer+"/schema", "schema must be a schema identifier or an inline schema")
+	}
+}
+
+// Validates that every reference made by the models can be resolved, that there are no circular dependencies, and
+// that the extends hierarchy is not too deep
+func (validator *modelValidator) validateReferences() {
+	entries := make([]*modelEntry, 0, len(validator.models))
+	byEntry := make(map[*modelEntry]*validatedModel)
+
+	for _, model := range validator.models {
+		validator.current = model
+		for _, reference := range model.entry.getModelDependencies() {
+			if _, ok := validator.definitions[reference.modelId]; !ok {
+				validator.report(errorSeverity, reference.pointer, "unresolved %s reference to '%s'", reference.kind, reference.modelId)
+			}
+		}
+
+		entries = append(entries, model.entry)
+		byEntry[model.entry] = model
+	}
+
+	setModelDependencies(entries)
+
+	_, err := sortModels(entries)
+	var cycleErr *CircularDependencyError
+	if errors.As(err, &cycleErr) {
+		for _, cycle := range cycleErr.Cycles {
+			validator.current = validator.byId[cycle[0]]
+			validator.report(errorSeverity, "", "circular dependency: %s", strings.Join(cycle, " -> "))
+		}
+		return
+	}
+
+	depths := make(map[*modelEntry]int)
+	for _, entry := range entries {
+		model := byEntry[entry]
+		if depth := extendsDepth(entry, depths); depth > model.version.maxExtendsDepth {
+			validator.current = model
+			validator.report(errorSeverity, "", "the extends hierarchy is %d levels deep, the maximum is %d", depth, model.version.maxExtendsDepth)
+		}
+	}
+}
+
+// Calculates how many levels of interfaces the entry extends, following both the interfaces it references and those
+// defined inline. The entries must not contain circular dependencies
+func extendsDepth(entry *modelEntry, depths map[*modelEntry]int) int {
+	if depth, ok := depths[entry]; ok {
+		return depth
+	}
+
+	dependencies := make(map[string]*modelEntry)
+	for _, dependency := range entry.dependencies {
+		dependencies[dependency.modelId] = dependency
+	}
+
+	depth := interfaceExtendsDepth(entry.model, dependencies, depths)
+	depths[entry] = depth
+	return depth
+}
+
+// Calculates how many levels of interfaces an interface extends, where referenced interfaces are resolved from the
+// dependencies of the model it is part of
+func interfaceExtendsDepth(element map[string]interface{}, dependencies map[string]*modelEntry, depths map[*modelEntry]int) int {
+	depth := 0
+	for _, item := range asArray(element["extends"]) {
+		parentDepth := 0
+		switch item := item.(type) {
+		case string:
+			dependency, ok := dependencies[item]
+			if !ok {
+				continue
+			}
+			parentDepth = extendsDepth(dependency, depths) + 1
+		case map[string]interface{}:
+			parentDepth = interfaceExtendsDepth(item, dependencies, depths) + 1
+		}
+
+		if parentDepth > depth {
+			depth = parentDepth
+		}
+	}
+	return depth
+}
+
+// Returns the first of the allowed types which the element has, or an empty string if it has none of them
+func firstType(element map[string]interface{}, allowed []string) string {
+	for _, typeName := range allowed {
+		if hasType(element, typeName) {
+			return typeName
+		}
+	}
+	return ""
+}
@@ -0,0 +1,201 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_validateModelFiles(t *testing.T) {
+	d := ModelDirectory{}
+	_ = d.Set("../testdata/validation")
+	files, _ := d.readFiles()
+
+	diagnostics := validateModelFiles(files)
+
+	expected := []struct {
+		file    string
+		line    int
+		column  int
+		message string
+	}{
+		{file: "badid.json", line: 3, column: 3, message: "is not a valid DTDL v2 identifier"},
+		{file: "room.json", line: 5, column: 3, message: "can extend at most 2 interfaces"},
+		{file: "room.json", line: 7, column: 5, message: "unresolved extends reference to 'dtmi:digitaltwins:testing:validation:area;1'"},
+		{file: "room.json", line: 13, column: 7, message: "name '1capacity' is not valid"},
+		{file: "room.json", line: 17, column: 7, message: "@type must include one of"},
+		{file: "room.json", line: 20, column: 5, message: "missing required property 'schema'"},
+		{file: "room.json", line: 26, column: 7, message: "duplicate name 'temperature'"},
+		{file: "space.json", line: 3, column: 3, message: "duplicate @id 'dtmi:digitaltwins:testing:validation:space;1'"},
+	}
+
+	for _, e := range expected {
+		found := false
+		for _, d := range diagnostics {
+			if filepath.Base(d.path) == e.file && d.line == e.line && d.column == e.column && strings.Contains(d.message, e.message) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Expected diagnostic '%s' at %s:%d:%d was not reported", e.message, e.file, e.line, e.column)
+		}
+	}
+
+	if len(diagnostics) != len(expected)+1 {
+		t.Errorf("Expected %d diagnostics, but got %d", len(expected)+1, len(diagnostics))
+	}
+}
+
+func Test_validateModelFiles_invalidFiles(t *testing.T) {
+	d := ModelDirectory{}
+	_ = d.Set("../testdata/models")
+	files, _ := d.readFiles()
+
+	diagnostics := validateModelFiles(files)
+
+	errorCount := 0
+	for _, d := range diagnostics {
+		if d.severity != errorSeverity {
+			continue
+		}
+		errorCount++
+
+		switch filepath.Base(d.path) {
+		case "notjson.dtdl":
+			if d.line != 1 || d.column != 1 || !strings.Contains(d.message, "not contain valid JSON") {
+				t.Errorf("Unexpected diagnostic for notjson.dtdl: %s", d)
+			}
+		case "invalid.dtdl":
+			if !strings.Contains(d.message, "missing required property '@id'") {
+				t.Errorf("Unexpected diagnostic for invalid.dtdl: %s", d)
+			}
+		default:
+			t.Errorf("Unexpected error: %s", d)
+		}
+	}
+
+	if errorCount != 2 {
+		t.Errorf("Expected 2 errors, but got %d", errorCount)
+	}
+}
+
+func Test_jsonPositions_locate(t *testing.T) {
+	content := []byte("{\n  \"a\": [\n    1,\n    {\"b\": true}\n  ]\n}")
+	positions := indexJsonPositions(content)
+
+	tests := []struct {
+		pointer string
+		line    int
+		column  int
+	}{
+		{pointer: "", line: 1, column: 1},
+		{pointer: "/a", line: 2, column: 3},
+		{pointer: "/a/0", line: 3, column: 5},
+		{pointer: "/a/1/b", line: 4, column: 6},
+		{pointer: "/a/1/missing", line: 4, column: 5},
+	}
+
+	for _, test := range tests {
+		line, column := positions.locate(test.pointer)
+		if line != test.line || column != test.column {
+			t.Errorf("Expected '%s' to be at %d:%d, but got %d:%d", test.pointer, test.line, test.column, line, column)
+		}
+	}
+}
+
+func Test_validateModelFiles_multipleModelsPerFile(t *testing.T) {
+	content := `[
+  {
+    "@id": "dtmi:digitaltwins:testing:multi:space;1",
+    "@type": "Interface",
+    "@context": "dtmi:dtdl:context;2"
+  },
+  {
+    "@id": "dtmi:digitaltwins:testing:multi:room;1",
+    "@type": "Interface",
+    "@context": "dtmi:dtdl:context;2",
+    "extends": "dtmi:digitaltwins:testing:multi:area;1"
+  },
+  "not a model"
+]
+{"@id": "dtmi:digitaltwins:testing:multi:level;1", "@type": "Interface", "@context": "dtmi:dtdl:context;2", "extends": "dtmi:digitaltwins:testing:multi:space;1"}
+{"@type": "Interface", "@context": "dtmi:dtdl:context;2"}
+`
+
+	diagnostics := validateModelFiles([]modelFile{{path: "ontology.json", content: []byte(content)}})
+
+	expected := []string{
+		"ontology.json:11:5: error: unresolved extends reference to 'dtmi:digitaltwins:testing:multi:area;1'",
+		"ontology.json:13:3: error: each model in the file must be a JSON object describing a DTDL interface",
+		"ontology.json:16:1: error: missing required property '@id'",
+	}
+
+	actual := make([]string, len(diagnostics))
+	for i, d := range diagnostics {
+		actual[i] = d.String()
+	}
+
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected diagnostics:\n%s\nbut got:\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
+
+func Test_validateModelFiles_inlineExtendsDepth(t *testing.T) {
+	inline := `"dtmi:digitaltwins:testing:depth:base;1"`
+	for i := 0; i < 10; i++ {
+		inline = fmt.Sprintf(`{"@type": "Interface", "extends": %s}`, inline)
+	}
+	content := `[
+{"@id": "dtmi:digitaltwins:testing:depth:base;1", "@type": "Interface", "@context": "dtmi:dtdl:context;2"},
+{"@id": "dtmi:digitaltwins:testing:depth:deep;1", "@type": "Interface", "@context": "dtmi:dtdl:context;2", "extends": ` + inline + `}
+]`
+
+	diagnostics := validateModelFiles([]modelFile{{path: "depth.json", content: []byte(content)}})
+
+	expected := "depth.json:3:1: error: the extends hierarchy is 11 levels deep, the maximum is 10"
+	if len(diagnostics) != 1 || diagnostics[0].String() != expected {
+		t.Errorf("Expected the inline interfaces to count towards the extends depth, but got: %v", diagnostics)
+	}
+}
+
+func Test_validateModelFiles_commandPayloads(t *testing.T) {
+	content := `{
+  "@id": "dtmi:digitaltwins:testing:command:thermostat;1",
+  "@type": "Interface",
+  "@context": "dtmi:dtdl:context;2",
+  "contents": [
+    {
+      "@type": "Command",
+      "name": "reboot",
+      "request": {"name": "delay", "schema": "integer"},
+      "response": {"@type": "CommandResponse", "name": "status", "schema": {"@type": "Enum", "valueSchema": "string", "enumValues": []}}
+    },
+    {
+      "@type": "Command",
+      "name": "setPoint",
+      "request": {"@type": "CommandResponse", "name": "1target"},
+      "response": "double"
+    }
+  ]
+}`
+
+	diagnostics := validateModelFiles([]modelFile{{path: "thermostat.json", content: []byte(content)}})
+
+	expected := []string{
+		"thermostat.json:15:7: error: missing required property 'schema'",
+		"thermostat.json:15:19: error: @type must be 'CommandRequest'",
+		"thermostat.json:15:47: error: name '1target' is not valid, it must start with a letter, contain only letters, digits and underscores, not end with an underscore, and be no more than 64 characters",
+		"thermostat.json:16:7: error: response must be an object",
+	}
+
+	actual := make([]string, len(diagnostics))
+	for i, d := range diagnostics {
+		actual[i] = d.String()
+	}
+
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected diagnostics:\n%s\nbut got:\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
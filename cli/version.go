@@ -0,0 +1,161 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// modelVersion is the version of a model, taken from the suffix of its id (e.g. ";2" or ";2.1" in DTDL v3). Ids without
+// a version have the zero version
+type modelVersion struct {
+	major int // The major version of the model
+	minor int // The minor version of the model, which is only used by DTDL v3
+}
+
+// Compares two versions, returning a negative number if the version is lower than the other, zero if they are the same,
+// and a positive number if it is higher
+func (version modelVersion) compare(other modelVersion) int {
+	if version.major != other.major {
+		return version.major - other.major
+	}
+	return version.minor - other.minor
+}
+
+// String returns the version in the form used in a model id
+func (version modelVersion) String() string {
+	if version.minor > 0 {
+		return fmt.Sprintf("%d.%d", version.major, version.minor)
+	}
+	return strconv.Itoa(version.major)
+}
+
+// Splits a model id into the id without its version, and the version. If the version suffix is not a valid version
+// then the whole id is treated as the base with the zero version
+func splitModelId(modelId string) (string, modelVersion) {
+	index := strings.LastIndex(modelId, ";")
+	if index < 0 {
+		return modelId, modelVersion{}
+	}
+
+	majorText, minorText, hasMinor := strings.Cut(modelId[index+1:], ".")
+
+	major, err := strconv.Atoi(majorText)
+	if err != nil || major < 0 {
+		return modelId, modelVersion{}
+	}
+
+	version := modelVersion{major: major}
+	if hasMinor {
+		minor, err := strconv.Atoi(minorText)
+		if err != nil || minor < 0 {
+			return modelId, modelVersion{}
+		}
+		version.minor = minor
+	}
+
+	return modelId[:index], version
+}
+
+// Gets the id of the next major version of a model, which is above the highest version of the model in the collection
+// of ids given
+func nextModelId(modelId string, existingIds []string) string {
+	base, version := splitModelId(modelId)
+	highest := version
+
+	for _, id := range existingIds {
+		if otherBase, otherVersion := splitModelId(id); otherBase == base && otherVersion.compare(highest) > 0 {
+			highest = otherVersion
+		}
+	}
+
+	return fmt.Sprintf("%s;%d", base, highest.major+1)
+}
+
+// modelUpgrade describes a local model which is a newer version of models in the Azure Digital Twin instance
+type modelUpgrade struct {
+	modelId  string   // The id of the new version of the model
+	previous []string // The ids of the older versions of the model in the instance, lowest version first
+}
+
+// Finds the models being uploaded which are newer versions of models in the instance. Models in the instance are only
+// treated as previous versions when they have a lower version than the model being uploaded
+func findUpgrades(uploading []*modelEntry, remote []*modelEntry) []modelUpgrade {
+	remoteVersions := make(map[string][]*modelEntry)
+	for _, entry := range remote {
+		base, _ := splitModelId(entry.modelId)
+		remoteVersions[base] = append(remoteVersions[base], entry)
+	}
+
+	upgrades := make([]modelUpgrade, 0)
+	for _, entry := range uploading {
+		base, version := splitModelId(entry.modelId)
+
+		older := make([]*modelEntry, 0)
+		for _, existing := range remoteVersions[base] {
+			if _, existingVersion := splitModelId(existing.modelId); existingVersion.compare(version) < 0 {
+				older = append(older, existing)
+			}
+		}
+
+		if len(older) == 0 {
+			continue
+		}
+
+		sort.Slice(older, func(i, j int) bool {
+			_, a := splitModelId(older[i].modelId)
+			_, b := splitModelId(older[j].modelId)
+			return a.compare(b) < 0
+		})
+
+		upgrade := modelUpgrade{modelId: entry.modelId}
+		for _, existing := range older {
+			upgrade.previous = append(upgrade.previous, existing.modelId)
+		}
+		upgrades = append(upgrades, upgrade)
+	}
+
+	return upgrades
+}
+
+// staleReference describes a model which references an older version of a model which has been upgraded
+type staleReference struct {
+	modelId    string        // The id of the model holding the reference
+	reference  string        // The id of the older version being referenced
+	kind       referenceKind // How the older version is referenced
+	replacedBy string        // The id of the version replacing the one referenced
+}
+
+// Finds the models which will still reference older versions of upgraded models once the upgrade is complete. The
+// older versions themselves are not reported, as they are being replaced
+func findStaleReferences(models []*modelEntry, upgrades []modelUpgrade) []staleReference {
+	replacedBy := make(map[string]string)
+	for _, upgrade := range upgrades {
+		for _, id := range upgrade.previous {
+			replacedBy[id] = upgrade.modelId
+		}
+	}
+
+	stale := make([]staleReference, 0)
+	for _, entry := range models {
+		if _, replaced := replacedBy[entry.modelId]; replaced {
+			continue
+		}
+
+		for _, reference := range entry.getModelDependencies() {
+			if newId, ok := replacedBy[reference.modelId]; ok {
+				stale = append(stale, staleReference{
+					modelId:    entry.modelId,
+					reference:  reference.modelId,
+					kind:       reference.kind,
+					replacedBy: newId,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(stale, func(i, j int) bool { return stale[i].modelId < stale[j].modelId })
+
+	return stale
+}
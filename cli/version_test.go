@@ -0,0 +1,183 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_splitModelId(t *testing.T) {
+	tests := []struct {
+		modelId         string
+		expectedBase    string
+		expectedVersion modelVersion
+	}{
+		{"dtmi:com:example:room;1", "dtmi:com:example:room", modelVersion{major: 1}},
+		{"dtmi:com:example:room;12", "dtmi:com:example:room", modelVersion{major: 12}},
+		{"dtmi:com:example:room;2.3", "dtmi:com:example:room", modelVersion{major: 2, minor: 3}},
+		{"dtmi:com:example:room", "dtmi:com:example:room", modelVersion{}},
+		{"dtmi:com:example:room;x", "dtmi:com:example:room;x", modelVersion{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.modelId, func(t *testing.T) {
+			base, version := splitModelId(tt.modelId)
+			if base != tt.expectedBase || version != tt.expectedVersion {
+				t.Errorf("Expected (%s, %s) but got (%s, %s)", tt.expectedBase, tt.expectedVersion, base, version)
+			}
+		})
+	}
+}
+
+func Test_nextModelId(t *testing.T) {
+	existing := []string{"dtmi:com:example:room;1", "dtmi:com:example:room;3", "dtmi:com:example:level;5"}
+
+	if next := nextModelId("dtmi:com:example:room;1", existing); next != "dtmi:com:example:room;4" {
+		t.Errorf("Expected dtmi:com:example:room;4 but got %s", next)
+	}
+
+	if next := nextModelId("dtmi:com:example:space;1", existing); next != "dtmi:com:example:space;2" {
+		t.Errorf("Expected dtmi:com:example:space;2 but got %s", next)
+	}
+}
+
+func Test_findUpgrades(t *testing.T) {
+	remote := []*modelEntry{
+		newTestEntry(t, `{"@id": "dtmi:com:example:space;2", "@type": "Interface"}`),
+		newTestEntry(t, `{"@id": "dtmi:com:example:space;1", "@type": "Interface"}`),
+		newTestEntry(t, `{"@id": "dtmi:com:example:room;1", "@type": "Interface", "extends": "dtmi:com:example:space;1"}`),
+		newTestEntry(t, `{"@id": "dtmi:com:example:level;4", "@type": "Interface"}`),
+	}
+
+	local := []*modelEntry{
+		newTestEntry(t, `{"@id": "dtmi:com:example:space;3", "@type": "Interface"}`),
+		newTestEntry(t, `{"@id": "dtmi:com:example:level;2", "@type": "Interface"}`),
+		newTestEntry(t, `{"@id": "dtmi:com:example:floor;1", "@type": "Interface", "extends": "dtmi:com:example:space;2"}`),
+	}
+
+	upgrades := findUpgrades(local, remote)
+	if len(upgrades) != 1 {
+		t.Fatalf("Expected 1 upgrade, but got %d", len(upgrades))
+	}
+
+	if upgrades[0].modelId != "dtmi:com:example:space;3" || strings.Join(upgrades[0].previous, ",") != "dtmi:com:example:space;1,dtmi:com:example:space;2" {
+		t.Errorf("Unexpected upgrade %s from %v", upgrades[0].modelId, upgrades[0].previous)
+	}
+
+	stale := findStaleReferences(append(local, remote...), upgrades)
+	if len(stale) != 2 {
+		t.Fatalf("Expected 2 stale references, but got %d", len(stale))
+	}
+
+	if stale[0].modelId != "dtmi:com:example:floor;1" || stale[0].reference != "dtmi:com:example:space;2" || stale[0].kind != extendsReference {
+		t.Errorf("Unexpected stale reference from %s to %s (%s)", stale[0].modelId, stale[0].reference, stale[0].kind)
+	}
+
+	if stale[1].modelId != "dtmi:com:example:room;1" || stale[1].replacedBy != "dtmi:com:example:space;3" {
+		t.Errorf("Unexpected stale reference from %s replaced by %s", stale[1].modelId, stale[1].replacedBy)
+	}
+}
+
+func Test_modelDiff_conflictErrorSuggestsVersion(t *testing.T) {
+	local := []*modelEntry{
+		newTestEntry(t, `{"@id": "dtmi:com:example:room;1", "@type": "Interface", "displayName": "Room"}`),
+	}
+	remote := []*modelEntry{
+		newTestEntry(t, `{"@id": "dtmi:com:example:room;1", "@type": "Interface"}`),
+		newTestEntry(t, `{"@id": "dtmi:com:example:room;2", "@type": "Interface"}`),
+	}
+
+	diff := diffModels(local, remote)
+	err := diff.conflictError()
+
+	var conflictErr *ModelConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("Expected a ModelConflictError, but got: %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "dtmi:com:example:room;1 (upload the changes as dtmi:com:example:room;3)") {
+		t.Errorf("Expected the error to suggest the next version, but got: %s", err)
+	}
+}
+
+func Test_findStaleReferences(t *testing.T) {
+	upgrades := []modelUpgrade{{modelId: "dtmi:com:example:space;2", previous: []string{"dtmi:com:example:space;1"}}}
+
+	models := []*modelEntry{
+		newTestEntry(t, `{"@id": "dtmi:com:example:space;1", "@type": "Interface",
+			"contents": [{"@type": "Relationship", "name": "contains", "target": "dtmi:com:example:space;1"}]}`),
+		newTestEntry(t, `{"@id": "dtmi:com:example:space;2", "@type": "Interface"}`),
+		newTestEntry(t, `{"@id": "dtmi:com:example:level;1", "@type": "Interface",
+			"contents": [{"@type": "Relationship", "name": "hasSpaces", "target": "dtmi:com:example:space;1"}]}`),
+		newTestEntry(t, `{"@id": "dtmi:com:example:building;1", "@type": "Interface",
+			"contents": [{"@type": "Component", "name": "lobby", "schema": "dtmi:com:example:space;1"}]}`),
+		newTestEntry(t, `{"@id": "dtmi:com:example:room;1", "@type": "Interface", "extends": "dtmi:com:example:space;2"}`),
+	}
+
+	stale := findStaleReferences(models, upgrades)
+
+	actual := make([]string, len(stale))
+	for i, reference := range stale {
+		actual[i] = fmt.Sprintf("%s -> %s (%s) replaced by %s", reference.modelId, reference.reference, reference.kind, reference.replacedBy)
+	}
+
+	expected := []string{
+		"dtmi:com:example:building;1 -> dtmi:com:example:space;1 (component) replaced by dtmi:com:example:space;2",
+		"dtmi:com:example:level;1 -> dtmi:com:example:space;1 (relationship) replaced by dtmi:com:example:space;2",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected stale references:\n%s\nbut got:\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
+
+func Test_printUpgrades(t *testing.T) {
+	upgrades := []modelUpgrade{{modelId: "dtmi:com:example:space;3", previous: []string{"dtmi:com:example:space;1", "dtmi:com:example:space;2"}}}
+	models := []*modelEntry{
+		newTestEntry(t, `{"@id": "dtmi:com:example:room;1", "@type": "Interface", "extends": "dtmi:com:example:space;2"}`),
+	}
+
+	var out strings.Builder
+	printUpgrades(&out, upgrades, models)
+
+	expected := "Upgrading dtmi:com:example:space;1, dtmi:com:example:space;2 to dtmi:com:example:space;3\n" +
+		"Warning: 1 reference(s) to older versions of upgraded models remain, the referencing models need a new version to use the upgrades:\n" +
+		"  dtmi:com:example:room;1 references dtmi:com:example:space;2 (extends), which is replaced by dtmi:com:example:space;3\n"
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\nbut got:\n%s", expected, out.String())
+	}
+
+	out.Reset()
+	printUpgrades(&out, nil, models)
+	if out.Len() != 0 {
+		t.Errorf("Expected nothing to be written without upgrades, but got:\n%s", out.String())
+	}
+}
+
+func Test_uploadModels_upgradesOnlyNewModels(t *testing.T) {
+	previous := `{"@id": "dtmi:com:example:space;1", "@type": "Interface"}`
+	current := `{"@id": "dtmi:com:example:space;2", "@type": "Interface"}`
+	source := newTestSource(t, current)
+	options := UploadOptions{JournalPath: filepath.Join(t.TempDir(), "upload.jsonl"), DecommissionOld: true}
+
+	// The new version already exists, so uploading the same models again is not an upgrade
+	instance := &fakeInstance{}
+	client := newFakeInstanceClient(t, instance, newTestEntry(t, previous), newTestEntry(t, current))
+	if err := uploadModels(context.Background(), client, "https://example.api.weu.digitaltwins.azure.net", source, options); err != nil {
+		t.Fatalf("Expected the upload to succeed, but got error: %s", err)
+	}
+	if len(instance.patched) != 0 {
+		t.Errorf("Expected no models to be decommissioned when nothing was uploaded, but %v were", instance.patched)
+	}
+
+	instance = &fakeInstance{}
+	client = newFakeInstanceClient(t, instance, newTestEntry(t, previous))
+	if err := uploadModels(context.Background(), client, "https://example.api.weu.digitaltwins.azure.net", source, options); err != nil {
+		t.Fatalf("Expected the upload to succeed, but got error: %s", err)
+	}
+	if strings.Join(instance.patched, ",") != "dtmi:com:example:space;1" {
+		t.Errorf("Expected the previous version to be decommissioned once the new version was uploaded, but got %v", instance.patched)
+	}
+}
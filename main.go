@@ -1,16 +1,32 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/dazfuller/adt/cli"
 	"io"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"time"
 )
 
-func validateCredentials(adtEndpoint string, useAzureCliCredentials bool, tenantId string, clientId string, clientSecret string) (*cli.AuthenticationMethod, error) {
+// The credential flags used by each authentication mode, where giving any other credential flag is an error
+var authenticationFlags = map[string][]string{
+	cli.AuthAzureCli:          {},
+	cli.AuthClientSecret:      {"tenant", "client-id", "client-secret"},
+	cli.AuthClientCertificate: {"tenant", "client-id", "client-certificate", "client-certificate-password"},
+	cli.AuthManagedIdentity:   {"client-id"},
+	cli.AuthWorkloadIdentity:  {"tenant", "client-id", "federated-token-file"},
+	cli.AuthDeviceCode:        {"tenant", "client-id"},
+	cli.AuthEnvironment:       {},
+	cli.AuthDefault:           {"tenant"},
+}
+
+func validateCredentials(adtEndpoint string, method cli.AuthenticationMethod) (*cli.AuthenticationMethod, error) {
 	if len(adtEndpoint) == 0 {
 		return nil, fmt.Errorf("the Azure Digital Twin endpoint must be set")
 	}
@@ -19,21 +35,81 @@ func validateCredentials(adtEndpoint string, useAzureCliCredentials bool, tenant
 		return nil, fmt.Errorf("the endpoint should start with https://")
 	}
 
-	if !useAzureCliCredentials && (len(tenantId) == 0 || len(clientId) == 0 || len(clientSecret) == 0) {
-		return nil, fmt.Errorf("when not using Azure CLI credentials for access then the tenant, client id, and client secret must be specified")
+	method.Mode = strings.ToLower(method.Mode)
+	if method.UseAzureCli {
+		if len(method.Mode) > 0 && method.Mode != cli.AuthAzureCli {
+			return nil, fmt.Errorf("-use-cli cannot be combined with -auth %s", method.Mode)
+		}
+		method.Mode = cli.AuthAzureCli
+	} else if len(method.Mode) == 0 {
+		method.Mode = cli.AuthClientSecret
+	}
+
+	switch method.Mode {
+	case cli.AuthAzureCli, cli.AuthManagedIdentity, cli.AuthDeviceCode, cli.AuthDefault:
+		// The tenant and client id are optional, such as the client id of a user-assigned managed identity
+	case cli.AuthClientSecret:
+		if len(method.TenantId) == 0 || len(method.ClientId) == 0 || len(method.ClientSecret) == 0 {
+			return nil, fmt.Errorf("when not using Azure CLI credentials for access then the tenant, client id, and client secret must be specified, or another method chosen with -auth")
+		}
+	case cli.AuthClientCertificate:
+		if len(method.TenantId) == 0 || len(method.ClientId) == 0 || len(method.CertificatePath) == 0 {
+			return nil, fmt.Errorf("when using certificate authentication the tenant, client id, and client certificate must be specified")
+		}
+		if _, err := os.Stat(method.CertificatePath); err != nil {
+			return nil, fmt.Errorf("unable to read the client certificate: %s", err)
+		}
+	case cli.AuthWorkloadIdentity:
+		// Workload identity in Kubernetes provides these through the environment of the pod
+		if len(method.TenantId) == 0 {
+			method.TenantId = os.Getenv("AZURE_TENANT_ID")
+		}
+		if len(method.ClientId) == 0 {
+			method.ClientId = os.Getenv("AZURE_CLIENT_ID")
+		}
+		if len(method.FederatedTokenFile) == 0 {
+			method.FederatedTokenFile = os.Getenv("AZURE_FEDERATED_TOKEN_FILE")
+		}
+		if len(method.TenantId) == 0 || len(method.ClientId) == 0 || len(method.FederatedTokenFile) == 0 {
+			return nil, fmt.Errorf("when using workload identity the tenant, client id, and federated token file must be specified, or set by AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_FEDERATED_TOKEN_FILE")
+		}
+	case cli.AuthEnvironment:
+		if len(os.Getenv("AZURE_TENANT_ID")) == 0 || len(os.Getenv("AZURE_CLIENT_ID")) == 0 ||
+			(len(os.Getenv("AZURE_CLIENT_SECRET")) == 0 && len(os.Getenv("AZURE_CLIENT_CERTIFICATE_PATH")) == 0 && len(os.Getenv("AZURE_USERNAME")) == 0) {
+			return nil, fmt.Errorf("when using environment authentication AZURE_TENANT_ID, AZURE_CLIENT_ID, and one of AZURE_CLIENT_SECRET, AZURE_CLIENT_CERTIFICATE_PATH or AZURE_USERNAME must be set")
+		}
+	default:
+		return nil, fmt.Errorf("the authentication mode '%s' is not valid, it should be one of %s", method.Mode, strings.Join(cli.AuthenticationModes, ", "))
+	}
+
+	// -use-cli has always ignored the other credential flags, so they are only checked when a mode is chosen with -auth
+	if method.UseAzureCli {
+		return &method, nil
+	}
+
+	given := []struct {
+		flag  string
+		value string
+	}{
+		{"tenant", method.TenantId},
+		{"client-id", method.ClientId},
+		{"client-secret", method.ClientSecret},
+		{"client-certificate", method.CertificatePath},
+		{"client-certificate-password", method.CertificatePassword},
+		{"federated-token-file", method.FederatedTokenFile},
 	}
 
-	var method cli.AuthenticationMethod
-	if useAzureCliCredentials {
-		method = cli.AuthenticationMethod{
-			UseAzureCli: true,
+	for _, credential := range given {
+		if len(credential.value) == 0 {
+			continue
+		}
+
+		used := false
+		for _, flag := range authenticationFlags[method.Mode] {
+			used = used || flag == credential.flag
 		}
-	} else {
-		method = cli.AuthenticationMethod{
-			UseAzureCli:  false,
-			TenantId:     tenantId,
-			ClientId:     clientId,
-			ClientSecret: clientSecret,
+		if !used {
+			return nil, fmt.Errorf("-%s cannot be used with -auth %s", credential.flag, method.Mode)
 		}
 	}
 
@@ -47,14 +123,32 @@ func highLevelUsageAndExit() {
 	fmt.Println("(such as model sorting to ensure that they are uploaded/deleted in dependency order)")
 	fmt.Println()
 	fmt.Println("List of commands:")
+	fmt.Println("  apply")
+	fmt.Println("        Runs the operations in a plan file against the Azure Digital Twin instance")
 	fmt.Println("  clear")
 	fmt.Println("        Removes all models from the Azure Digital Twin instance")
+	fmt.Println("  decommission")
+	fmt.Println("        Decommissions models in the Azure Digital Twin instance matching model ids or patterns (e.g. dtmi:com:example:*;1)")
+	fmt.Println("  delete")
+	fmt.Println("        Removes models from the Azure Digital Twin instance matching model ids or patterns, and optionally the models which depend on them")
+	fmt.Println("  diff")
+	fmt.Println("        Compares a set of models from local storage with the models in the Azure Digital Twin instance")
 	fmt.Println("  download")
 	fmt.Println("        Downloads all models from the Azure Digital Twin instance and structures them in the output location based on their model id")
+	fmt.Println("  fmt")
+	fmt.Println("        Rewrites a set of models from local storage with their properties in the canonical DTDL order, or checks that they are formatted")
+	fmt.Println("  graph")
+	fmt.Println("        Writes the graph of references between models, from local storage or the Azure Digital Twin instance, as DOT, Mermaid or JSON")
+	fmt.Println("  impact")
+	fmt.Println("        Lists the models, and counts the twins, which would be affected by a change to a model")
 	fmt.Println("  list")
 	fmt.Println("        Lists the model ids currently deployed to the Azure Digital Twin instance")
+	fmt.Println("  plan")
+	fmt.Println("        Writes the operations needed to make the Azure Digital Twin instance match a set of models from local storage to a plan file")
 	fmt.Println("  upload")
 	fmt.Println("        Uploads a set of models from local storage to the Azure Digital Twin instance")
+	fmt.Println("  validate")
+	fmt.Println("        Validates a set of models from local storage against the DTDL rules without connecting to an instance")
 	fmt.Println()
 	os.Exit(0)
 }
@@ -65,29 +159,157 @@ func main() {
 	var tenantId string
 	var clientId string
 	var clientSecret string
+	var authMode string
+	var clientCertificate string
+	var clientCertificatePassword string
+	var federatedTokenFile string
 	var verbose bool
+	var dryRun bool
+	var confirmed bool
+	var allowProtected bool
 	var source cli.ModelDirectory
+	var output cli.OutputDirectory
 	var fileExtension string
+	var outputFormat string
+	var exitCode bool
+	var planPath string
+	var orphans string
+	var maxRetries int
+	var maxRetryDelay time.Duration
+	var maxRetryAfter time.Duration
+	var requestTimeout time.Duration
+	var timeout time.Duration
+	var concurrency int
+	var maxRequestBytes int
+	var journalPath string
+	var resume bool
+	var atomic bool
+	var patternFile string
+	var decommissionOld bool
+	var cascade bool
+	var graphFormat string
+	var graphRoot string
+	var graphDepth int
+	var graphReverse bool
+	var singleFile string
+	var layout string
+	var clean bool
+	var includePatterns cli.PatternList
+	var excludePatterns cli.PatternList
+	var canonical bool
+	var formatCheck bool
+	var indent string
+	var lineEndings string
 
 	var selectedFlagSet *flag.FlagSet = nil
+	requiresConnection := true
 
 	listCommand := flag.NewFlagSet("list", flag.ExitOnError)
 	clearCommand := flag.NewFlagSet("clear", flag.ExitOnError)
 	uploadCommand := flag.NewFlagSet("upload", flag.ExitOnError)
 	downloadCommand := flag.NewFlagSet("download", flag.ExitOnError)
+	validateCommand := flag.NewFlagSet("validate", flag.ExitOnError)
+	diffCommand := flag.NewFlagSet("diff", flag.ExitOnError)
+	planCommand := flag.NewFlagSet("plan", flag.ExitOnError)
+	applyCommand := flag.NewFlagSet("apply", flag.ExitOnError)
+	decommissionCommand := flag.NewFlagSet("decommission", flag.ExitOnError)
+	deleteCommand := flag.NewFlagSet("delete", flag.ExitOnError)
+	graphCommand := flag.NewFlagSet("graph", flag.ExitOnError)
+	impactCommand := flag.NewFlagSet("impact", flag.ExitOnError)
+	formatCommand := flag.NewFlagSet("fmt", flag.ExitOnError)
 
-	uploadCommand.Var(&source, "source", "Directory containing the model files to upload")
-	downloadCommand.Var(&source, "output", "Directory to write models to during download")
+	uploadCommand.Var(&source, "source", "Directory, .zip or .tar.gz archive, or - for stdin, containing the model files to upload")
+	uploadCommand.StringVar(&journalPath, "journal", cli.DefaultJournalPath, "File to record the progress of the upload in, relative to the working directory, so that it can be resumed if it fails")
+	uploadCommand.BoolVar(&resume, "resume", false, "Continues a failed upload from the first batch which the journal does not record as uploaded")
+	uploadCommand.BoolVar(&atomic, "atomic", false, "Removes the models created by the upload if any batch fails, leaving the instance as it was")
+	uploadCommand.BoolVar(&decommissionOld, "decommission-old", false, "Decommissions older versions of the uploaded models once the upload is complete")
+	uploadCommand.IntVar(&maxRequestBytes, "max-request-bytes", cli.DefaultMaxRequestBytes, "Maximum size in bytes of the body of each request used to upload models")
+	planCommand.IntVar(&maxRequestBytes, "max-request-bytes", cli.DefaultMaxRequestBytes, "Maximum size in bytes of the body of each request used to create models")
+	uploadCommand.Usage = func() {
+		fmt.Printf("Usage of upload:\n  adt upload [flags]\n\n")
+		fmt.Printf("The progress of the upload is recorded in a journal (%s in the working directory by default), which is\n", cli.DefaultJournalPath)
+		fmt.Printf("removed once the upload completes. If the upload fails after uploading some batches then the journal is kept,\n")
+		fmt.Printf("and the upload must be continued with -resume, or the journal removed, before it can be run again. If no\n")
+		fmt.Printf("batches were uploaded then the journal is removed and the upload can simply be run again.\n\n")
+		uploadCommand.PrintDefaults()
+	}
+	downloadCommand.Var(&output, "output", "Directory to write models to during download")
 	downloadCommand.StringVar(&fileExtension, "ext", "dtdl", "File extension to use for files downloaded (valid values are 'dtdl' or 'json')")
+	downloadCommand.StringVar(&singleFile, "single-file", "", "Writes all models as a JSON array to a file with this name in the output directory")
+	downloadCommand.StringVar(&layout, "layout", cli.LayoutDefault, "Layout of the files written, either 'default', 'flat', 'namespace', or a template using {segments}, {namespace}, {name}, {version}, {dtmi} and {ext} (e.g. {segments}/{name}_{version}.{ext})")
+	downloadCommand.BoolVar(&clean, "clean", false, "Removes everything in the output directory before writing the models")
+	downloadCommand.BoolVar(&canonical, "canonical", false, "Writes the models with their properties in the canonical DTDL order, using -indent and -line-endings")
+	formatCommand.Var(&source, "source", "Directory, .zip or .tar.gz archive, or - for stdin, containing the model files to format")
+	formatCommand.BoolVar(&formatCheck, "check", false, "Lists the files which are not formatted, exiting with a status of 1 if there are any, instead of writing them")
+	for _, fs := range []*flag.FlagSet{downloadCommand, formatCommand} {
+		fs.StringVar(&indent, "indent", "2", "Indentation to use, either a number of spaces from 0 to 8 or 'tab'")
+		fs.StringVar(&lineEndings, "line-endings", cli.LineEndingsLF, "Line endings to use (valid values are 'lf' or 'crlf')")
+	}
+	for _, fs := range []*flag.FlagSet{clearCommand, uploadCommand, downloadCommand, decommissionCommand, deleteCommand} {
+		fs.BoolVar(&dryRun, "dry-run", false, "Prints the requests and file writes which would be made without making any changes")
+	}
+	for _, fs := range []*flag.FlagSet{clearCommand, uploadCommand, downloadCommand} {
+		fs.Var(&includePatterns, "include", "Only include models matching this model id (e.g. dtmi:com:example:*;*) or file path pattern, can be repeated")
+		fs.Var(&excludePatterns, "exclude", "Exclude models matching this model id or file path pattern, can be repeated")
+	}
+	clearCommand.BoolVar(&confirmed, "yes", false, "Removes the models without asking for confirmation")
+	clearCommand.IntVar(&concurrency, "concurrency", cli.DefaultConcurrency, "Maximum number of models to remove at the same time")
+	clearCommand.BoolVar(&allowProtected, "allow-protected", false, "Allows models to be removed from an instance listed as a protected endpoint")
+	decommissionCommand.StringVar(&patternFile, "file", "", "File containing model ids or patterns to decommission, one per line")
+	decommissionCommand.BoolVar(&confirmed, "yes", false, "Decommissions the models without asking for confirmation")
+	decommissionCommand.BoolVar(&allowProtected, "allow-protected", false, "Allows models to be decommissioned in an instance listed as a protected endpoint")
+	decommissionCommand.Usage = func() {
+		fmt.Printf("Usage of decommission:\n  adt decommission [flags] <model id or pattern>...\n")
+		decommissionCommand.PrintDefaults()
+	}
+	deleteCommand.StringVar(&patternFile, "file", "", "File containing model ids or patterns to delete, one per line")
+	deleteCommand.BoolVar(&cascade, "cascade", false, "Also removes every model which depends on the models being deleted")
+	deleteCommand.BoolVar(&confirmed, "yes", false, "Removes the models without asking for confirmation")
+	deleteCommand.BoolVar(&allowProtected, "allow-protected", false, "Allows models to be removed from an instance listed as a protected endpoint")
+	deleteCommand.IntVar(&concurrency, "concurrency", cli.DefaultConcurrency, "Maximum number of models to remove at the same time")
+	deleteCommand.Usage = func() {
+		fmt.Printf("Usage of delete:\n  adt delete [flags] <model id or pattern>...\n")
+		deleteCommand.PrintDefaults()
+	}
+	graphCommand.Var(&source, "source", "Directory, .zip or .tar.gz archive, or - for stdin, containing the model files to graph, instead of the models in the instance")
+	graphCommand.StringVar(&graphFormat, "format", cli.GraphFormatDot, "Format to write the graph in (valid values are 'dot', 'mermaid' or 'json')")
+	graphCommand.StringVar(&graphRoot, "root", "", "Only include the models reachable from this model id")
+	graphCommand.IntVar(&graphDepth, "depth", 0, "Maximum number of references to follow from the root model, with 0 meaning no limit")
+	graphCommand.BoolVar(&graphReverse, "reverse", false, "Follow references to the models which depend on each model instead")
+	impactCommand.Var(&source, "source", "Directory, .zip or .tar.gz archive, or - for stdin, containing the model files to analyse, instead of the models in the instance")
+	impactCommand.Usage = func() {
+		fmt.Printf("Usage of impact:\n  adt impact [flags] <model id>\n")
+		impactCommand.PrintDefaults()
+	}
+	validateCommand.Var(&source, "source", "Directory, .zip or .tar.gz archive, or - for stdin, containing the model files to validate")
+	validateCommand.BoolVar(&verbose, "verbose", false, "Indicates if logging output should be displayed")
+	diffCommand.Var(&source, "source", "Directory, .zip or .tar.gz archive, or - for stdin, containing the model files to compare")
+	diffCommand.StringVar(&outputFormat, "format", "text", "Format to write the differences in (valid values are 'text' or 'json')")
+	diffCommand.BoolVar(&exitCode, "exit-code", false, "Exit with a status of 1 if there are differences")
+	planCommand.Var(&source, "source", "Directory, .zip or .tar.gz archive, or - for stdin, containing the model files the instance should match")
+	planCommand.StringVar(&planPath, "out", "adt.plan.json", "File to write the plan to, relative to the working directory")
+	planCommand.StringVar(&orphans, "orphans", cli.OrphanDelete, "How to handle models which only exist in the instance (valid values are 'delete', 'decommission' or 'keep')")
+	applyCommand.StringVar(&planPath, "plan", "adt.plan.json", "File containing the plan to apply")
+	applyCommand.BoolVar(&confirmed, "yes", false, "Applies a plan which removes or decommissions models without asking for confirmation")
+	applyCommand.BoolVar(&allowProtected, "allow-protected", false, "Allows the plan to be applied to an instance listed as a protected endpoint")
 
 	// Set up common flags
-	for _, fs := range []*flag.FlagSet{listCommand, clearCommand, uploadCommand, downloadCommand} {
+	for _, fs := range []*flag.FlagSet{listCommand, clearCommand, uploadCommand, downloadCommand, diffCommand, planCommand, applyCommand, decommissionCommand, deleteCommand, graphCommand, impactCommand} {
 		fs.StringVar(&adtEndpoint, "endpoint", "", "Endpoint of the Azure digital twin instance (e.g. https://my-twin.api.weu.digitaltwins.azure.net)")
 		fs.BoolVar(&useAzureCliCredentials, "use-cli", false, "Indicates if the credentials of the Azure CLI should be used")
 		fs.StringVar(&tenantId, "tenant", "", "ID of the tenant to authenticate the client credentials against")
-		fs.StringVar(&clientId, "client-id", "", "ID (app id) of the app registration being used for authentication")
+		fs.StringVar(&authMode, "auth", "", "How to authenticate (valid values are '"+strings.Join(cli.AuthenticationModes, "', '")+"'), defaults to 'secret' unless -use-cli is set")
+		fs.StringVar(&clientId, "client-id", "", "ID (app id) of the app registration being used for authentication, or the client id of a user-assigned managed identity")
 		fs.StringVar(&clientSecret, "client-secret", "", "Secret for the app registration being used for authentication")
+		fs.StringVar(&clientCertificate, "client-certificate", "", "PEM or PFX file holding the certificate and private key of the app registration, when using certificate authentication")
+		fs.StringVar(&clientCertificatePassword, "client-certificate-password", "", "Password of the client certificate, if it has one")
+		fs.StringVar(&federatedTokenFile, "federated-token-file", "", "File holding the federated token for workload identity, defaults to AZURE_FEDERATED_TOKEN_FILE")
 		fs.BoolVar(&verbose, "verbose", false, "Indicates if logging output should be displayed")
+		fs.IntVar(&maxRetries, "max-retries", cli.DefaultRetryPolicy().MaxRetries, "Maximum number of times a throttled or failed request is retried")
+		fs.DurationVar(&maxRetryDelay, "max-retry-delay", cli.DefaultRetryPolicy().MaxDelay, "Maximum time to wait between retries, unless the instance asks for longer")
+		fs.DurationVar(&maxRetryAfter, "max-retry-after", cli.DefaultRetryPolicy().MaxRetryAfter, "Maximum time to wait when the instance asks for a longer delay between retries")
+		fs.DurationVar(&requestTimeout, "request-timeout", cli.DefaultRequestTimeout, "Maximum time a single request to the instance is allowed to take")
+		fs.DurationVar(&timeout, "timeout", 0, "Maximum time the command is allowed to run for (e.g. 10m), with no limit by default")
 	}
 
 	if len(os.Args) < 2 {
@@ -108,6 +330,10 @@ func main() {
 			os.Exit(-1)
 		}
 		_ = clearCommand.Parse(os.Args[2:])
+		if concurrency < 1 {
+			clearCommand.Usage()
+			os.Exit(-1)
+		}
 		selectedFlagSet = clearCommand
 	case "upload":
 		if len(os.Args) < 5 {
@@ -115,6 +341,10 @@ func main() {
 			os.Exit(-1)
 		}
 		_ = uploadCommand.Parse(os.Args[2:])
+		if maxRequestBytes < 1 {
+			uploadCommand.Usage()
+			os.Exit(-1)
+		}
 		selectedFlagSet = uploadCommand
 	case "download":
 		if len(os.Args) < 5 {
@@ -127,41 +357,247 @@ func main() {
 		}
 		_ = downloadCommand.Parse(os.Args[2:])
 		selectedFlagSet = downloadCommand
+	case "validate":
+		if len(os.Args) < 4 {
+			validateCommand.Usage()
+			os.Exit(-1)
+		}
+		_ = validateCommand.Parse(os.Args[2:])
+		selectedFlagSet = validateCommand
+		requiresConnection = false
+	case "diff":
+		if len(os.Args) < 5 {
+			diffCommand.Usage()
+			os.Exit(-1)
+		}
+		_ = diffCommand.Parse(os.Args[2:])
+		if outputFormat != "text" && outputFormat != "json" {
+			diffCommand.Usage()
+			os.Exit(-1)
+		}
+		selectedFlagSet = diffCommand
+	case "plan":
+		if len(os.Args) < 5 {
+			planCommand.Usage()
+			os.Exit(-1)
+		}
+		_ = planCommand.Parse(os.Args[2:])
+		if (orphans != cli.OrphanDelete && orphans != cli.OrphanDecommission && orphans != cli.OrphanKeep) || maxRequestBytes < 1 {
+			planCommand.Usage()
+			os.Exit(-1)
+		}
+		selectedFlagSet = planCommand
+	case "apply":
+		if len(os.Args) < 4 {
+			applyCommand.Usage()
+			os.Exit(-1)
+		}
+		_ = applyCommand.Parse(os.Args[2:])
+		selectedFlagSet = applyCommand
+	case "decommission":
+		if len(os.Args) < 4 {
+			decommissionCommand.Usage()
+			os.Exit(-1)
+		}
+		_ = decommissionCommand.Parse(os.Args[2:])
+		if decommissionCommand.NArg() == 0 && len(patternFile) == 0 {
+			decommissionCommand.Usage()
+			os.Exit(-1)
+		}
+		selectedFlagSet = decommissionCommand
+	case "delete":
+		if len(os.Args) < 4 {
+			deleteCommand.Usage()
+			os.Exit(-1)
+		}
+		_ = deleteCommand.Parse(os.Args[2:])
+		if (deleteCommand.NArg() == 0 && len(patternFile) == 0) || concurrency < 1 {
+			deleteCommand.Usage()
+			os.Exit(-1)
+		}
+		selectedFlagSet = deleteCommand
+	case "graph":
+		if len(os.Args) < 4 {
+			graphCommand.Usage()
+			os.Exit(-1)
+		}
+		_ = graphCommand.Parse(os.Args[2:])
+		if graphFormat != cli.GraphFormatDot && graphFormat != cli.GraphFormatMermaid && graphFormat != cli.GraphFormatJson {
+			graphCommand.Usage()
+			os.Exit(-1)
+		}
+		selectedFlagSet = graphCommand
+		requiresConnection = len(source.Path) == 0
+	case "impact":
+		if len(os.Args) < 4 {
+			impactCommand.Usage()
+			os.Exit(-1)
+		}
+		_ = impactCommand.Parse(os.Args[2:])
+		if impactCommand.NArg() != 1 {
+			impactCommand.Usage()
+			os.Exit(-1)
+		}
+		selectedFlagSet = impactCommand
+		// Twins are only counted when an endpoint is given, so credentials are optional for local models
+		requiresConnection = len(source.Path) == 0 || len(adtEndpoint) > 0
+	case "fmt":
+		if len(os.Args) < 4 {
+			formatCommand.Usage()
+			os.Exit(-1)
+		}
+		_ = formatCommand.Parse(os.Args[2:])
+		selectedFlagSet = formatCommand
+		requiresConnection = false
 	default:
 		highLevelUsageAndExit()
 	}
 
-	authenticationMethod, err := validateCredentials(adtEndpoint, useAzureCliCredentials, tenantId, clientId, clientSecret)
-	if err != nil {
-		fmt.Printf("An error occured parsing the arguments: %s\n", err)
-		selectedFlagSet.Usage()
-		os.Exit(-1)
+	filter := cli.ModelFilter{Include: includePatterns, Exclude: excludePatterns}
+
+	var connection cli.Connection
+	if requiresConnection {
+		authenticationMethod, err := validateCredentials(adtEndpoint, cli.AuthenticationMethod{
+			Mode:                authMode,
+			UseAzureCli:         useAzureCliCredentials,
+			TenantId:            tenantId,
+			ClientId:            clientId,
+			ClientSecret:        clientSecret,
+			CertificatePath:     clientCertificate,
+			CertificatePassword: clientCertificatePassword,
+			FederatedTokenFile:  federatedTokenFile,
+		})
+		if err == nil && maxRetries < 0 {
+			err = fmt.Errorf("the maximum number of retries cannot be negative")
+		}
+		if err == nil && requestTimeout <= 0 {
+			err = fmt.Errorf("the request timeout must be greater than zero")
+		}
+		if err != nil {
+			fmt.Printf("An error occured parsing the arguments: %s\n", err)
+			selectedFlagSet.Usage()
+			os.Exit(-1)
+		}
+
+		retry := cli.DefaultRetryPolicy()
+		retry.MaxRetries = maxRetries
+		retry.MaxDelay = maxRetryDelay
+		retry.MaxRetryAfter = maxRetryAfter
+		connection = cli.Connection{Endpoint: adtEndpoint, Authentication: authenticationMethod, Retry: retry, RequestTimeout: requestTimeout}
 	}
 
 	if !verbose {
 		log.SetOutput(io.Discard)
 	}
 
+	// Cancel the command when interrupted, or when it runs for longer than the timeout
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		// Restore the default behaviour so that a second interrupt ends the process immediately
+		<-ctx.Done()
+		stop()
+	}()
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
 	if listCommand.Parsed() {
-		err := cli.ListModels(adtEndpoint, authenticationMethod)
+		err := cli.ListModels(ctx, connection)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(-2)
 		}
 	} else if clearCommand.Parsed() {
-		err := cli.ClearModels(adtEndpoint, authenticationMethod)
+		err := cli.ClearModels(ctx, connection, cli.ClearOptions{DryRun: dryRun, Confirmed: confirmed, AllowProtected: allowProtected, Concurrency: concurrency, Filter: filter})
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(-2)
 		}
 	} else if uploadCommand.Parsed() {
-		err := cli.UploadModels(adtEndpoint, authenticationMethod, source)
+		err := cli.UploadModels(ctx, connection, source, cli.UploadOptions{DryRun: dryRun, MaxRequestBytes: maxRequestBytes, JournalPath: journalPath, Resume: resume, Atomic: atomic, DecommissionOld: decommissionOld, Filter: filter})
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(-2)
 		}
 	} else if downloadCommand.Parsed() {
-		err := cli.DownloadModels(adtEndpoint, authenticationMethod, source, fileExtension)
+		options := cli.DownloadOptions{FileExtension: fileExtension, DryRun: dryRun, SingleFile: singleFile, Layout: layout, Clean: clean, Filter: filter}
+		if canonical {
+			options.Format = &cli.FormatOptions{Indent: indent, LineEndings: lineEndings}
+		}
+		err := cli.DownloadModels(ctx, connection, output.ModelDirectory, options)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-2)
+		}
+	} else if validateCommand.Parsed() {
+		err := cli.ValidateModels(source)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-2)
+		}
+	} else if diffCommand.Parsed() {
+		err := cli.DiffModels(ctx, connection, source, outputFormat)
+		if errors.Is(err, cli.ErrModelsDiffer) {
+			if exitCode {
+				os.Exit(1)
+			}
+		} else if err != nil {
+			fmt.Println(err)
+			os.Exit(-2)
+		}
+	} else if planCommand.Parsed() {
+		err := cli.PlanModels(ctx, connection, source, planPath, cli.PlanOptions{Orphans: orphans, MaxRequestBytes: maxRequestBytes})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-2)
+		}
+	} else if decommissionCommand.Parsed() {
+		err := cli.DecommissionModels(ctx, connection, decommissionCommand.Args(), cli.DecommissionOptions{File: patternFile, DryRun: dryRun, Confirmed: confirmed, AllowProtected: allowProtected})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-2)
+		}
+	} else if deleteCommand.Parsed() {
+		options := cli.DeleteOptions{
+			File:           patternFile,
+			Cascade:        cascade,
+			DryRun:         dryRun,
+			Confirmed:      confirmed,
+			AllowProtected: allowProtected,
+			Concurrency:    concurrency,
+		}
+		err := cli.DeleteModels(ctx, connection, deleteCommand.Args(), options)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-2)
+		}
+	} else if graphCommand.Parsed() {
+		options := cli.GraphOptions{Source: source, Format: graphFormat, Root: graphRoot, Depth: graphDepth, Reverse: graphReverse}
+		err := cli.GraphModels(ctx, connection, options)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-2)
+		}
+	} else if impactCommand.Parsed() {
+		err := cli.ImpactModels(ctx, connection, impactCommand.Arg(0), cli.ImpactOptions{Source: source})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-2)
+		}
+	} else if formatCommand.Parsed() {
+		err := cli.FormatModels(source, cli.FormatOptions{Indent: indent, LineEndings: lineEndings, Check: formatCheck})
+		if errors.Is(err, cli.ErrNotFormatted) {
+			os.Exit(1)
+		} else if err != nil {
+			fmt.Println(err)
+			os.Exit(-2)
+		}
+	} else if applyCommand.Parsed() {
+		err := cli.ApplyPlan(ctx, connection, planPath, cli.ApplyOptions{Confirmed: confirmed, AllowProtected: allowProtected})
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(-2)
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"github.com/dazfuller/adt/cli"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_validateCredentials(t *testing.T) {
+	certificatePath := filepath.Join(t.TempDir(), "client.pem")
+	_ = os.WriteFile(certificatePath, []byte("certificate"), 0644)
+
+	endpoint := "https://example.api.weu.digitaltwins.azure.net"
+
+	tests := []struct {
+		name          string
+		method        cli.AuthenticationMethod
+		environment   map[string]string
+		expectedMode  string
+		expectedError string
+	}{
+		{"Client secret by default", cli.AuthenticationMethod{TenantId: "tenant", ClientId: "client", ClientSecret: "secret"}, nil, cli.AuthClientSecret, ""},
+		{"Client secret without a secret", cli.AuthenticationMethod{TenantId: "tenant", ClientId: "client"}, nil, "", "the tenant, client id, and client secret must be specified"},
+		{"Use CLI", cli.AuthenticationMethod{UseAzureCli: true, ClientSecret: "ignored"}, nil, cli.AuthAzureCli, ""},
+		{"Use CLI with another mode", cli.AuthenticationMethod{UseAzureCli: true, Mode: cli.AuthDeviceCode}, nil, "", "-use-cli cannot be combined with -auth device-code"},
+		{"CLI", cli.AuthenticationMethod{Mode: "CLI"}, nil, cli.AuthAzureCli, ""},
+		{"CLI with a client secret", cli.AuthenticationMethod{Mode: cli.AuthAzureCli, ClientSecret: "secret"}, nil, "", "-client-secret cannot be used with -auth cli"},
+		{"Certificate", cli.AuthenticationMethod{Mode: cli.AuthClientCertificate, TenantId: "tenant", ClientId: "client", CertificatePath: certificatePath, CertificatePassword: "password"}, nil, cli.AuthClientCertificate, ""},
+		{"Certificate without a tenant", cli.AuthenticationMethod{Mode: cli.AuthClientCertificate, ClientId: "client", CertificatePath: certificatePath}, nil, "", "the tenant, client id, and client certificate must be specified"},
+		{"Certificate which does not exist", cli.AuthenticationMethod{Mode: cli.AuthClientCertificate, TenantId: "tenant", ClientId: "client", CertificatePath: certificatePath + ".missing"}, nil, "", "unable to read the client certificate"},
+		{"Certificate with a client secret", cli.AuthenticationMethod{Mode: cli.AuthClientCertificate, TenantId: "tenant", ClientId: "client", CertificatePath: certificatePath, ClientSecret: "secret"}, nil, "", "-client-secret cannot be used with -auth certificate"},
+		{"System-assigned managed identity", cli.AuthenticationMethod{Mode: cli.AuthManagedIdentity}, nil, cli.AuthManagedIdentity, ""},
+		{"User-assigned managed identity", cli.AuthenticationMethod{Mode: cli.AuthManagedIdentity, ClientId: "client"}, nil, cli.AuthManagedIdentity, ""},
+		{"Managed identity with a client secret", cli.AuthenticationMethod{Mode: cli.AuthManagedIdentity, ClientId: "client", ClientSecret: "secret"}, nil, "", "-client-secret cannot be used with -auth managed-identity"},
+		{"Managed identity with a tenant", cli.AuthenticationMethod{Mode: cli.AuthManagedIdentity, TenantId: "tenant"}, nil, "", "-tenant cannot be used with -auth managed-identity"},
+		{"Workload identity from flags", cli.AuthenticationMethod{Mode: cli.AuthWorkloadIdentity, TenantId: "tenant", ClientId: "client", FederatedTokenFile: "/var/run/token"}, nil, cli.AuthWorkloadIdentity, ""},
+		{"Workload identity from the environment", cli.AuthenticationMethod{Mode: cli.AuthWorkloadIdentity}, map[string]string{"AZURE_TENANT_ID": "tenant", "AZURE_CLIENT_ID": "client", "AZURE_FEDERATED_TOKEN_FILE": "/var/run/token"}, cli.AuthWorkloadIdentity, ""},
+		{"Workload identity without a token file", cli.AuthenticationMethod{Mode: cli.AuthWorkloadIdentity, TenantId: "tenant", ClientId: "client"}, nil, "", "the tenant, client id, and federated token file must be specified"},
+		{"Workload identity with a certificate", cli.AuthenticationMethod{Mode: cli.AuthWorkloadIdentity, TenantId: "tenant", ClientId: "client", FederatedTokenFile: "/var/run/token", CertificatePath: certificatePath}, nil, "", "-client-certificate cannot be used with -auth workload-identity"},
+		{"Device code", cli.AuthenticationMethod{Mode: cli.AuthDeviceCode}, nil, cli.AuthDeviceCode, ""},
+		{"Device code with a tenant and client", cli.AuthenticationMethod{Mode: cli.AuthDeviceCode, TenantId: "tenant", ClientId: "client"}, nil, cli.AuthDeviceCode, ""},
+		{"Device code with a client secret", cli.AuthenticationMethod{Mode: cli.AuthDeviceCode, ClientSecret: "secret"}, nil, "", "-client-secret cannot be used with -auth device-code"},
+		{"Environment", cli.AuthenticationMethod{Mode: cli.AuthEnvironment}, map[string]string{"AZURE_TENANT_ID": "tenant", "AZURE_CLIENT_ID": "client", "AZURE_CLIENT_SECRET": "secret"}, cli.AuthEnvironment, ""},
+		{"Environment without a secret", cli.AuthenticationMethod{Mode: cli.AuthEnvironment}, map[string]string{"AZURE_TENANT_ID": "tenant", "AZURE_CLIENT_ID": "client"}, "", "one of AZURE_CLIENT_SECRET, AZURE_CLIENT_CERTIFICATE_PATH or AZURE_USERNAME must be set"},
+		{"Environment with a client id", cli.AuthenticationMethod{Mode: cli.AuthEnvironment, ClientId: "client"}, map[string]string{"AZURE_TENANT_ID": "tenant", "AZURE_CLIENT_ID": "client", "AZURE_CLIENT_SECRET": "secret"}, "", "-client-id cannot be used with -auth environment"},
+		{"Default", cli.AuthenticationMethod{Mode: cli.AuthDefault}, nil, cli.AuthDefault, ""},
+		{"Default with a tenant", cli.AuthenticationMethod{Mode: cli.AuthDefault, TenantId: "tenant"}, nil, cli.AuthDefault, ""},
+		{"Default with a client secret", cli.AuthenticationMethod{Mode: cli.AuthDefault, ClientSecret: "secret"}, nil, "", "-client-secret cannot be used with -auth default"},
+		{"Unknown mode", cli.AuthenticationMethod{Mode: "password"}, nil, "", "the authentication mode 'password' is not valid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, variable := range []string{"AZURE_TENANT_ID", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET", "AZURE_CLIENT_CERTIFICATE_PATH", "AZURE_USERNAME", "AZURE_FEDERATED_TOKEN_FILE"} {
+				t.Setenv(variable, tt.environment[variable])
+			}
+
+			method, err := validateCredentials(endpoint, tt.method)
+
+			if len(tt.expectedError) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Errorf("Expected error containing '%s', but got: %v", tt.expectedError, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Expected the credentials to be valid, but got error: %s", err)
+			}
+			if method.Mode != tt.expectedMode {
+				t.Errorf("Expected mode %s but got %s", tt.expectedMode, method.Mode)
+			}
+		})
+	}
+}
+
+func Test_validateCredentials_endpoint(t *testing.T) {
+	method := cli.AuthenticationMethod{Mode: cli.AuthDefault}
+
+	if _, err := validateCredentials("", method); err == nil || !strings.Contains(err.Error(), "endpoint must be set") {
+		t.Errorf("Expected an error for a missing endpoint, but got: %v", err)
+	}
+
+	if _, err := validateCredentials("http://example.api.weu.digitaltwins.azure.net", method); err == nil || !strings.Contains(err.Error(), "should start with https://") {
+		t.Errorf("Expected an error for an endpoint which is not https, but got: %v", err)
+	}
+}